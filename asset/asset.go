@@ -0,0 +1,233 @@
+package asset
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// Type is the kind of an asset, it selects validation and normalization
+type Type string
+
+const (
+	DOMAIN   Type = "domain"
+	IP       Type = "ip"
+	CIDR     Type = "cidr"
+	URL      Type = "url"
+	HOSTPORT Type = "hostport"
+	EMAIL    Type = "email"
+	TEXT     Type = "text"
+)
+
+// TYPE_DEFAULT is used for outputs without asset_type
+const TYPE_DEFAULT = DOMAIN
+
+var TYPES = []Type{DOMAIN, IP, CIDR, URL, HOSTPORT, EMAIL, TEXT}
+
+// Valid checks if t is a known type
+func (t Type) Valid() bool {
+	for _, v := range TYPES {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// HasHost reports whether assets of a type contain a host which can be
+// resolved. Assets without a type are treated as domains.
+func (t Type) HasHost() bool {
+	switch t {
+	case "", DOMAIN, IP, URL, HOSTPORT:
+		return true
+	}
+	return false
+}
+
+// Normalize validates an asset of a type and returns its canonical form:
+// hosts are lower cased without trailing dots, international domains
+// are converted to punycode and URLs are canonicalized.
+func Normalize(t Type, s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("empty %v", t)
+	}
+
+	switch t {
+	case DOMAIN:
+		return normalizeDomain(s)
+	case IP:
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return "", fmt.Errorf("invalid ip %q", s)
+		}
+		return ip.String(), nil
+	case CIDR:
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return "", err
+		}
+		return network.String(), nil
+	case URL:
+		return normalizeURL(s)
+	case HOSTPORT:
+		host, port, err := net.SplitHostPort(s)
+		if err != nil {
+			return "", err
+		}
+		if host, err = normalizeHost(host); err != nil {
+			return "", err
+		}
+		if port, err = normalizePort(port); err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(host, port), nil
+	case EMAIL:
+		at := strings.LastIndex(s, "@")
+		if at <= 0 || strings.ContainsAny(s[:at], " \t<>") {
+			return "", fmt.Errorf("invalid email %q", s)
+		}
+		domain, err := normalizeDomain(s[at+1:])
+		if err != nil {
+			return "", err
+		}
+		return s[:at] + "@" + domain, nil
+	case TEXT:
+		return s, nil
+	}
+
+	return "", fmt.Errorf("unknown asset type %q", t)
+}
+
+func normalizeDomain(s string) (string, error) {
+	name := strings.TrimSuffix(strings.ToLower(s), ".")
+
+	name, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("domain: %v", err)
+	}
+
+	if name == "" {
+		return "", fmt.Errorf("empty domain")
+	}
+
+	return name, validateDomain(name)
+}
+
+// normalizeHost normalizes a domain or an ip
+func normalizeHost(s string) (string, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String(), nil
+	}
+	return normalizeDomain(s)
+}
+
+func normalizePort(s string) (string, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("invalid port %q", s)
+	}
+	return strconv.Itoa(port), nil
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// normalizeURL lower cases scheme and host, removes default ports and
+// fragments and adds an empty path
+func normalizeURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme == "" || u.Host == "" || u.Opaque != "" {
+		return "", fmt.Errorf("invalid url %q", s)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	if port != "" {
+		if port, err = normalizePort(port); err != nil {
+			return "", err
+		}
+	}
+
+	if port == "" || port == defaultPorts[u.Scheme] {
+		u.Host = host
+		if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
+		}
+	} else {
+		u.Host = net.JoinHostPort(host, port)
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+
+	return u.String(), nil
+}
+
+// validateDomain is based on the cookie domain validation of net/http
+func validateDomain(name string) error {
+	if len(name) > 255 {
+		return fmt.Errorf("domain: name length is %d, can't exceed 255", len(name))
+	}
+	var l int
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		if b == '.' {
+			// check domain labels validity
+			switch {
+			case i == l:
+				return fmt.Errorf("domain: invalid character '%c' at offset %d: label can't begin with a period", b, i)
+			case i-l > 63:
+				return fmt.Errorf("domain: byte length of label '%s' is %d, can't exceed 63", name[l:i], i-l)
+			case name[l] == '-':
+				return fmt.Errorf("domain: label '%s' at offset %d begins with a hyphen", name[l:i], l)
+			case name[i-1] == '-':
+				return fmt.Errorf("domain: label '%s' at offset %d ends with a hyphen", name[l:i], l)
+			}
+			l = i + 1
+			continue
+		}
+		// test label character validity, note: tests are ordered by decreasing validity frequency
+		if !(b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '-' || b >= 'A' && b <= 'Z') {
+			// show the printable unicode character starting at byte offset i
+			c, _ := utf8.DecodeRuneInString(name[i:])
+			if c == utf8.RuneError {
+				return fmt.Errorf("domain: invalid rune at offset %d", i)
+			}
+			return fmt.Errorf("domain: invalid character '%c' at offset %d", c, i)
+		}
+	}
+	// check top level domain validity
+	switch {
+	case l == len(name):
+		return fmt.Errorf("domain: missing top level domain, domain can't end with a period")
+	case len(name)-l > 63:
+		return fmt.Errorf("domain: byte length of top level domain '%s' is %d, can't exceed 63", name[l:], len(name)-l)
+	case name[l] == '-':
+		return fmt.Errorf("domain: top level domain '%s' at offset %d begins with a hyphen", name[l:], l)
+	case name[len(name)-1] == '-':
+		return fmt.Errorf("domain: top level domain '%s' at offset %d ends with a hyphen", name[l:], l)
+	case name[l] >= '0' && name[l] <= '9':
+		return fmt.Errorf("domain: top level domain '%s' at offset %d begins with a digit", name[l:], l)
+	}
+	return nil
+}
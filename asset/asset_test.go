@@ -0,0 +1,66 @@
+package asset
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		typ   Type
+		asset string
+		want  string
+	}{
+		{DOMAIN, "WWW.Example.com.", "www.example.com"},
+		{DOMAIN, "bücher.example", "xn--bcher-kva.example"},
+		{IP, "10.0.0.1", "10.0.0.1"},
+		{IP, "2001:DB8::0:1", "2001:db8::1"},
+		{CIDR, "10.1.2.3/8", "10.0.0.0/8"},
+		{URL, "HTTPS://Example.COM:443#top", "https://example.com/"},
+		{URL, "http://example.com:8080/a?b=1", "http://example.com:8080/a?b=1"},
+		{URL, "http://[2001:db8::1]:80/", "http://[2001:db8::1]/"},
+		{URL, "https://bücher.example/", "https://xn--bcher-kva.example/"},
+		{HOSTPORT, "Example.com.:0443", "example.com:443"},
+		{HOSTPORT, "[2001:db8::1]:22", "[2001:db8::1]:22"},
+		{EMAIL, "Admin@Example.COM", "Admin@example.com"},
+		{TEXT, "  some banner ", "some banner"},
+	}
+
+	for _, tt := range tests {
+		got, err := Normalize(tt.typ, tt.asset)
+		if err != nil {
+			t.Errorf("%v %q: %v", tt.typ, tt.asset, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("%v %q: want = %q, got = %q", tt.typ, tt.asset, tt.want, got)
+		}
+	}
+}
+
+func TestNormalizeErrors(t *testing.T) {
+	tests := []struct {
+		typ   Type
+		asset string
+	}{
+		{DOMAIN, ""},
+		{DOMAIN, "10.0.0.1"},
+		{DOMAIN, "-foo.com"},
+		{DOMAIN, "foo bar.com"},
+		{IP, "example.com"},
+		{CIDR, "10.0.0.1"},
+		{URL, "example.com/path"},
+		{URL, "mailto:a@example.com"},
+		{URL, "http://example.com:99999/"},
+		{HOSTPORT, "example.com"},
+		{HOSTPORT, "example.com:http"},
+		{EMAIL, "example.com"},
+		{EMAIL, "@example.com"},
+		{TEXT, " "},
+		{Type("foo"), "bar"},
+	}
+
+	for _, tt := range tests {
+		if got, err := Normalize(tt.typ, tt.asset); err == nil {
+			t.Errorf("%v %q: want error, got %q", tt.typ, tt.asset, got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/rverton/pipers/db"
+	"github.com/rverton/pipers/pipe"
+	"github.com/rverton/pipers/scope"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds global settings which are not specific to a pipe
+type Config struct {
+	Retention db.RetentionPolicy
+
+	// log every handled task in pipers_tasks, defaults to true
+	TaskAudit *bool `yaml:"task_audit"`
+
+	// key_by_target and per table settings of data tables
+	Layout db.Layout `yaml:",inline"`
+
+	Scope db.Scope
+
+	// resolution of assets before they are processed
+	Hosts pipe.HostCheck
+
+	// IP blocklist files in addition to -blacklist
+	Blocklists pipe.Blocklists
+}
+
+func (c Config) AuditTasks() bool {
+	return c.TaskAudit == nil || *c.TaskAudit
+}
+
+// Load reads a yaml config file, a missing file results in the defaults
+func Load(filename string) (Config, error) {
+	var c Config
+
+	f, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return c, err
+	}
+
+	if err := yaml.UnmarshalStrict(f, &c); err != nil {
+		return c, err
+	}
+
+	if err := c.Retention.Validate(); err != nil {
+		return c, err
+	}
+
+	if _, err := scope.New(c.Scope.Targets); err != nil {
+		return c, err
+	}
+
+	if err := c.Hosts.Validate(); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SaveOptions control how Save treats an already existing record
+type SaveOptions struct {
+	// Track lists data fields which are compared with the stored
+	// record. If one of them changed, the record is updated and the
+	// previous version is moved to pipers_history.
+	Track []string
+
+	// AssetType is recorded with the record, see asset.Type. An empty
+	// type keeps the stored one.
+	AssetType string
+
+	// Source is the input record the record was produced from, it is
+	// recorded in pipers_lineage
+	Source *Source
+}
+
+// SaveResult describes what Save did with a record
+type SaveResult struct {
+	Inserted    bool
+	Updated     bool
+	Reactivated bool // the record was marked as removed before
+	Changes     Changes
+}
+
+// Change is a single modified field of an updated record
+type Change struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+type Changes []Change
+
+func (c Changes) String() string {
+	var s []string
+	for _, change := range c {
+		s = append(s, fmt.Sprintf("%v: '%v' -> '%v'", change.Field, jsonText(change.Old), jsonText(change.New)))
+	}
+	return strings.Join(s, ", ")
+}
+
+// diffFields compares the tracked fields of a stored and a new record
+func diffFields(old, new map[string]interface{}, track []string) Changes {
+	var changes Changes
+
+	for _, field := range track {
+		o, oldOk := old[field]
+		n, newOk := new[field]
+
+		if oldOk == newOk && jsonText(o) == jsonText(n) {
+			continue
+		}
+
+		changes = append(changes, Change{Field: field, Old: o, New: n})
+	}
+
+	return changes
+}
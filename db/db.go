@@ -14,39 +14,102 @@ import (
 )
 
 type Data struct {
-	Id     string                 `json:"id"`
-	Asset  string                 `json:"asset"`
-	Target string                 `json:"target"`
-	Pipe   string                 `json:"pipe"`
-	Data   map[string]interface{} `json:"data"` // JSONB
+	Id        string                 `json:"id"`
+	Asset     string                 `json:"asset"`
+	AssetType string                 `json:"asset_type"`
+	Target    string                 `json:"target"`
+	Pipe      string                 `json:"pipe"`
+	Data      map[string]interface{} `json:"data"` // JSONB
 }
 
 type Alert struct {
-	Type  string
-	Pipe  string
-	Ident string
+	Type    string    `json:"type"`
+	Pipe    string    `json:"pipe"`
+	Target  string    `json:"target"`
+	Ident   string    `json:"ident"`
+	Message string    `json:"message"`
+	Created time.Time `json:"created_at"`
+}
+
+// History is a previous version of a record, saved when one of
+// its tracked fields changed
+type History struct {
+	Table   string                 `json:"table"`
+	Target  string                 `json:"target"`
+	Ident   string                 `json:"ident"`
+	Pipe    string                 `json:"pipe"`
+	Data    map[string]interface{} `json:"data"`
+	Created time.Time              `json:"created_at"`
 }
 
 type Task struct {
 	Pipe    string    `json:"pipe"`
+	Table   string    `json:"table"` // source of the ident, see pipe.Source
+	Target  string    `json:"target"`
 	Ident   string    `json:"ident"`
+	TaskId  string    `json:"task_id"` // id of the queued task, if any
 	Note    string    `json:"note"`
 	Created time.Time `json:"created_at"`
 }
 
+// DataService is implemented by all backends. Callers which only need
+// a part of it accept one of the role interfaces below.
 type DataService interface {
+	TaskService
+	RecordService
+	TargetService
+	PauseService
+	AdminService
+}
+
+// TaskService records runs of pipes and decides what is due
+type TaskService interface {
 	AddTask(t Task) error
-	ShouldRun(pipe, ident string, interval time.Duration) bool
-	Retrieve(table, pipeName string, fields map[string]string, threshold map[string]string, interval time.Duration) (pgx.Rows, error)
+	Due(pipe, table, target string, idents []string, interval time.Duration) ([]string, error)
+}
+
+// RecordService reads the input and saves the output of pipes
+type RecordService interface {
+	Retrieve(table, pipeName string, filter Filter, interval time.Duration) ([]Data, error)
+	RetrieveBlocked(target string) ([]string, error)
+	RetrieveByTarget(table string, filter Filter, target string) ([]Data, error)
+	RetrieveIncrement(table, pipeName, target string, filter Filter, refresh time.Duration) (Increment, error)
+	CommitIncrement(table, pipeName string, inc Increment) error
+	Save(table, pipe, id string, data Data, result map[string]interface{}, opts SaveOptions) (SaveResult, error)
+	SaveAlert(pipe, target, id, msg, alertType string) error
+	MarkRemoved(table, pipe string, after time.Duration) ([]Data, error)
+}
+
+// TargetService manages the registered targets
+type TargetService interface {
+	AddTarget(t Target) error
 	RetrieveTargets() ([]string, error)
-	RetrieveBlocked() ([]string, error)
-	RetrieveByTarget(table string, fields map[string]string, target string) (pgx.Rows, error)
-	Save(table, pipe, id string, data Data, result map[string]interface{}) (bool, error)
-	SaveAlert(pipe string, id, msg, alertType string) error
+	ListTargets() ([]Target, error)
+	RemoveTarget(name string, tables []string) (map[string]int64, error)
+	CountRecords(table string) (map[string]int64, error)
+}
+
+// PauseService pauses and resumes pipes and targets
+type PauseService interface {
+	PauseTarget(name string, paused bool) error
+	PausePipe(name string, paused bool) error
+	RetrievePaused() (Paused, error)
+}
+
+// AdminService backs the maintenance commands
+type AdminService interface {
+	Prune(table, target string, maxAge time.Duration, dryRun bool) (int64, error)
+	Lineage(table, target, ident string, descendants bool) ([]Lineage, error)
+	Exclude(table, target, ident string, opts ExcludeOptions) ([]Record, error)
+	Include(table, target, ident string) ([]Record, error)
+	Export(table string, q ExportQuery, fn func(Row) error) error
 }
 
 type PostgresService struct {
-	DB *pgxpool.Pool
+	DB            *pgxpool.Pool
+	SkipTaskAudit bool // do not log tasks in pipers_tasks
+	Layout        Layout
+	Scope         Scope
 }
 
 func InitDb(uri string) (*pgxpool.Pool, error) {
@@ -63,8 +126,23 @@ func InitDb(uri string) (*pgxpool.Pool, error) {
 	return db, err
 }
 
+// AddTask records that a pipe was run on an input. The last run is
+// used to decide what is due, the task itself is only kept as audit log.
 func (d *PostgresService) AddTask(t Task) error {
-	if _, err := d.DB.Exec(context.Background(), "INSERT INTO pipers_tasks (pipe, ident, note) VALUES ($1, $2, $3)", t.Pipe, t.Ident, t.Note); err != nil {
+	ctx := context.Background()
+
+	_, err := d.DB.Exec(
+		ctx,
+		`INSERT INTO pipers_last_run (pipe, tbl, target, ident, run_at) VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (pipe, tbl, target, ident) DO UPDATE SET run_at = EXCLUDED.run_at`,
+		t.Pipe, t.Table, t.Target, t.Ident,
+	)
+
+	if err == nil && !d.SkipTaskAudit {
+		_, err = d.DB.Exec(ctx, "INSERT INTO pipers_tasks (pipe, tbl, target, ident, task_id, note) VALUES ($1, $2, $3, $4, $5, $6)", t.Pipe, t.Table, t.Target, t.Ident, t.TaskId, t.Note)
+	}
+
+	if err != nil {
 		log.WithFields(log.Fields{
 			"task":  t,
 			"error": err,
@@ -75,24 +153,37 @@ func (d *PostgresService) AddTask(t Task) error {
 	return nil
 }
 
-func (d *PostgresService) ShouldRun(pipe, ident string, interval time.Duration) bool {
-	var n int64
+// Due returns all idents of a target for which the pipe did not run
+// within the interval
+func (d *PostgresService) Due(pipe, table, target string, idents []string, interval time.Duration) ([]string, error) {
+	recent := make(map[string]struct{})
 
-	err := d.DB.QueryRow(
-		context.Background(),
-		"SELECT 1 from pipers_tasks WHERE pipe = $1 and ident = $2 and created_at > NOW() - $3::interval LIMIT 1",
-		pipe,
-		ident,
-		interval.Truncate(time.Millisecond).String(),
-	).Scan(&n)
+	for _, batch := range batches(idents, DUE_BATCH_SIZE) {
+		rows, err := d.DB.Query(
+			context.Background(),
+			"SELECT ident FROM pipers_last_run WHERE pipe = $1 AND tbl = $2 AND target = $3 AND ident = ANY($4) AND run_at > NOW() - $5::interval",
+			pipe, table, target, batch, interval,
+		)
+		if err != nil {
+			return nil, err
+		}
 
-	if err == pgx.ErrNoRows {
-		return true
-	} else if err != nil {
-		log.Errorf("cant count recent tasks: %v ", err)
+		for rows.Next() {
+			var ident string
+			if err := rows.Scan(&ident); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			recent[ident] = struct{}{}
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
 	}
 
-	return false
+	return withoutIdents(idents, recent), nil
 }
 
 func tableExists(db *pgxpool.Pool, name string) bool {
@@ -107,108 +198,286 @@ func tableExists(db *pgxpool.Pool, name string) bool {
 	return true
 }
 
+// SetupDb migrates the essential tables and all passed data tables
+// using the default layout
 func SetupDb(db *pgxpool.Pool, tables []string) error {
-	_, err := db.Exec(context.Background(), SQL_CREATE_ESSENTIALS)
+	return (&PostgresService{DB: db}).Migrate(tables)
+}
+
+func (d *PostgresService) Migrate(tables []string) error {
+	status, err := d.MigrationStatus(tables)
 	if err != nil {
-		return fmt.Errorf("unable to create essential tables: %v", err)
+		return err
+	}
+
+	for _, s := range status {
+		for _, m := range s.Pending {
+			if err := d.applyMigration(s.Scope, m); err != nil {
+				return fmt.Errorf("migration %v (%v) of %v failed: %v", m.Version, m.Name, s.Scope, err)
+			}
+
+			log.WithFields(log.Fields{
+				"scope":   s.Scope,
+				"version": m.Version,
+			}).Infof("applied migration '%v'", m.Name)
+		}
 	}
 
-	for _, name := range tables {
+	return d.applyLayout(tables)
+}
+
+// applyLayout changes the primary key of data tables which do not
+// match the configured layout
+func (d *PostgresService) applyLayout(tables []string) error {
+	ctx := context.Background()
 
-		// create table with SQL_CREATE_DATA_TBL
-		sql := fmt.Sprintf(SQL_CREATE_DATA_TBL, name, name, name, name, name)
-		_, err := db.Exec(context.Background(), sql)
+	for _, table := range tables {
+		var columns int
+		err := d.DB.QueryRow(
+			ctx,
+			"SELECT array_length(indkey::int2[], 1) FROM pg_index WHERE indrelid = $1::regclass AND indisprimary",
+			table,
+		).Scan(&columns)
 		if err != nil {
-			return fmt.Errorf("unable to create %v table: %v", name, err)
+			return fmt.Errorf("reading primary key of %v failed: %v", table, err)
 		}
 
+		byTarget := d.Layout.KeyedByTarget(table)
+		if byTarget == (columns == 2) {
+			continue
+		}
+
+		key := "id"
+		if byTarget {
+			key = "target, id"
+		}
+
+		sql := fmt.Sprintf(`
+			ALTER TABLE %[1]v DROP CONSTRAINT %[1]v_pkey, ADD PRIMARY KEY (%[2]v);
+			CREATE INDEX IF NOT EXISTS %[1]v_id_idx ON %[1]v (id);
+		`, table, key)
+
+		if _, err := d.DB.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("changing primary key of %v to (%v) failed: %v", table, key, err)
+		}
+
+		log.WithFields(log.Fields{
+			"table": table,
+		}).Infof("changed primary key to (%v)", key)
 	}
 
 	return nil
 }
 
-// retrieve will return rows from the passed table, filtered by fields
-// and only where no tasks is found (or the task is older than the passed
-// interval. note that this function is vulnerable to sqli, but because
-// a pipe in itself executes user commands, it does not matter here.
-func (d *PostgresService) Retrieve(table string, pipeName string, fields map[string]string, threshold map[string]string, interval time.Duration) (pgx.Rows, error) {
+func (d *PostgresService) applyMigration(scope string, m Migration) error {
+	ctx := context.Background()
+
+	tx, err := d.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, migrationSQL(scope, m.Postgres)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "INSERT INTO pipers_schema_version (scope, version, name) VALUES ($1, $2, $3)", scope, m.Version, m.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (d *PostgresService) MigrationStatus(tables []string) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+
+	if _, err := d.DB.Exec(context.Background(), SQL_CREATE_SCHEMA_VERSION); err != nil {
+		return status, fmt.Errorf("unable to create schema version table: %v", err)
+	}
+
+	for _, scope := range migrationScopes(tables) {
+		var version int
+		err := d.DB.QueryRow(
+			context.Background(),
+			"SELECT COALESCE(MAX(version), 0) FROM pipers_schema_version WHERE scope = $1",
+			scope,
+		).Scan(&version)
+		if err != nil {
+			return status, err
+		}
+
+		status = append(status, migrationStatus(scope, version))
+	}
+
+	return status, nil
+}
+
+// retrieve will return rows from the passed table, filtered by the input
+// filter and only where the pipe did not run (or the last run is older
+// than the passed interval). note that the table name is not escaped, but
+// because a pipe in itself executes user commands, it does not matter here.
+func (d *PostgresService) Retrieve(table string, pipeName string, filter Filter, interval time.Duration) ([]Data, error) {
 	sql := fmt.Sprintf(`
 		SELECT
-			A.id, A.asset, A.target, A.data
+			A.id, A.asset, A.target, A.data, A.asset_type
 		FROM 
 			%v A
-			LEFT JOIN pipers_tasks T
-			ON A.id = T.ident AND T.pipe = $1 AND T.created_at > NOW() - $2::interval
-		WHERE T.ident IS NULL AND A.exclude = false
+			LEFT JOIN pipers_last_run L
+			ON L.pipe = $1 AND L.tbl = $2 AND L.target = A.target AND L.ident = A.id AND L.run_at > NOW() - $3::interval
+		WHERE L.ident IS NULL AND A.exclude = false AND A.active = true
 	`, table)
 
-	var filterQuery []string
-	var thresholdQuery []string
+	args := []interface{}{pipeName, table, interval}
 
-	args := []interface{}{pipeName, interval}
-	count := 2
+	where, filterArgs := filter.compile(&filterCompiler{alias: "A.", dollar: true, offset: len(args)})
+	if where != "" {
+		sql += " AND " + where
+		args = append(args, filterArgs...)
+	}
 
-	// boolean filter from pipe
-	for k, v := range fields {
-		filterQuery = append(
-			filterQuery,
-			fmt.Sprintf("(data ->> $%v) = $%v", count+1, count+2),
-		)
-		args = append(args, k)
-		args = append(args, v)
-		count++
+	return d.query(sql, args...)
+}
+
+func (d *PostgresService) RetrieveByTarget(table string, filter Filter, target string) ([]Data, error) {
+	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+
+	query := psql.Select("id, asset, target, data, asset_type").From(table)
+	query = query.Where("target = ?", target)
+	query = query.Where("exclude = false")
+	query = query.Where("active = true")
+
+	if where, args := filter.compile(&filterCompiler{}); where != "" {
+		query = query.Where(where, args...)
+	}
+
+	sql, args, err := query.ToSql()
+
+	if err != nil {
+		return nil, err
 	}
 
-	if len(filterQuery) > 0 {
-		sql = fmt.Sprintf("%v AND ", sql)
+	return d.query(sql, args...)
+}
+
+// RetrieveIncrement returns the records created after the watermark of
+// their target, optionally only of a single target. If refresh is set,
+// targets which were not loaded completely within it are refreshed.
+func (d *PostgresService) RetrieveIncrement(table, pipeName, target string, filter Filter, refresh time.Duration) (Increment, error) {
+	var inc Increment
+	ctx := context.Background()
+
+	if err := d.DB.QueryRow(ctx, "SELECT (NOW() - $1::interval)::timestamp::text", INCREMENT_LAG).Scan(&inc.Mark); err != nil {
+		return inc, err
 	}
 
-	sql = fmt.Sprintf("%v%v", sql, strings.Join(filterQuery, " AND "))
+	args := []interface{}{pipeName, table, inc.Mark}
 
-	// threshold from pipe
-	for k, v := range threshold {
-		thresholdQuery = append(
-			thresholdQuery,
-			fmt.Sprintf("(data ->> $%v)::NUMERIC > $%v::NUMERIC", count+1, count+2),
-		)
-		args = append(args, k)
-		args = append(args, v)
-		count++
+	from := fmt.Sprintf(`
+		%v A
+		LEFT JOIN pipers_watermarks W
+		ON W.pipe = $1 AND W.tbl = $2 AND W.target = A.target
+	`, table)
+	where := "A.exclude = false AND A.active = true AND A.created_at <= $3::timestamp"
+
+	if target != "" {
+		args = append(args, target)
+		where += fmt.Sprintf(" AND A.target = $%v", len(args))
 	}
 
-	if len(thresholdQuery) > 0 {
-		sql = fmt.Sprintf("%v AND ", sql)
+	full := "W.mark IS NULL"
+	if refresh > 0 {
+		args = append(args, refresh)
+		full += fmt.Sprintf(" OR W.refreshed_at IS NULL OR W.refreshed_at < NOW() - $%v::interval", len(args))
 	}
 
-	sql = fmt.Sprintf("%v%v", sql, strings.Join(thresholdQuery, " AND "))
+	rows, err := d.DB.Query(ctx, fmt.Sprintf("SELECT DISTINCT A.target FROM %v WHERE %v AND (%v)", from, where, full), args...)
+	if err != nil {
+		return inc, err
+	}
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			rows.Close()
+			return inc, err
+		}
+		inc.Refreshed = append(inc.Refreshed, t)
+	}
+	rows.Close()
 
-	return d.DB.Query(context.Background(), sql, args...)
-}
+	if err := rows.Err(); err != nil {
+		return inc, err
+	}
 
-func (d *PostgresService) RetrieveByTarget(table string, fields map[string]string, target string) (pgx.Rows, error) {
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sql := fmt.Sprintf("SELECT A.id, A.asset, A.target, A.data, A.asset_type FROM %v WHERE %v AND (%v OR A.created_at > W.mark)", from, where, full)
 
-	query := psql.Select("id, asset, target, data").From(table)
-	query = query.Where("target = ?", target)
-	query = query.Where("exclude = false")
+	filterSQL, filterArgs := filter.compile(&filterCompiler{alias: "A.", dollar: true, offset: len(args)})
+	if filterSQL != "" {
+		sql += " AND " + filterSQL
+		args = append(args, filterArgs...)
+	}
+
+	inc.Rows, err = d.query(sql+" ORDER BY A.created_at", args...)
+	return inc, err
+}
 
-	for k, v := range fields {
-		query = query.Where("(data ->> ?) = ?", k, v)
+// CommitIncrement moves the watermarks of all targets of an increment,
+// it is called after the increment was enqueued
+func (d *PostgresService) CommitIncrement(table, pipeName string, inc Increment) error {
+	for _, target := range inc.targets() {
+		_, err := d.DB.Exec(
+			context.Background(),
+			`INSERT INTO pipers_watermarks (pipe, tbl, target, mark, refreshed_at)
+			VALUES ($1, $2, $3, $4::timestamp, CASE WHEN $5 THEN NOW() END)
+			ON CONFLICT (pipe, tbl, target) DO UPDATE SET
+				mark = EXCLUDED.mark,
+				refreshed_at = COALESCE(EXCLUDED.refreshed_at, pipers_watermarks.refreshed_at)`,
+			pipeName, table, target, inc.Mark, inc.refreshed(target),
+		)
+		if err != nil {
+			return err
+		}
 	}
 
-	sql, args, err := query.ToSql()
+	return nil
+}
 
+// query scans data records selected by id, asset, target, data and asset_type
+func (d *PostgresService) query(sql string, args ...interface{}) ([]Data, error) {
+	rows, err := d.DB.Query(context.Background(), sql, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var result []Data
+	for rows.Next() {
+		var data Data
+		if err := rows.Scan(&data.Id, &data.Asset, &data.Target, &data.Data, &data.AssetType); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
 
-	return d.DB.Query(context.Background(), sql, args...)
+	return result, rows.Err()
 }
 
+// AddTarget registers a target or updates its description and data
+func (d *PostgresService) AddTarget(t Target) error {
+	_, err := d.DB.Exec(
+		context.Background(),
+		`INSERT INTO pipers_targets (name, description, data) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description, data = EXCLUDED.data`,
+		t.Name, t.Description, t.Data,
+	)
+	return err
+}
+
+// RetrieveTargets returns the names of all registered targets
 func (d *PostgresService) RetrieveTargets() ([]string, error) {
 
 	var targets []string
-	rows, err := d.DB.Query(context.Background(), "SELECT DISTINCT target FROM domains")
+	rows, err := d.DB.Query(context.Background(), "SELECT name FROM pipers_targets ORDER BY name")
 	if err != nil {
 		return targets, err
 	}
@@ -224,10 +493,159 @@ func (d *PostgresService) RetrieveTargets() ([]string, error) {
 	return targets, err
 }
 
-func (d *PostgresService) RetrieveBlocked() ([]string, error) {
+// ListTargets returns all registered targets ordered by name
+func (d *PostgresService) ListTargets() ([]Target, error) {
+	rows, err := d.DB.Query(
+		context.Background(),
+		"SELECT name, description, data, paused, created_at FROM pipers_targets ORDER BY name",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []Target
+	for rows.Next() {
+		var t Target
+		if err := rows.Scan(&t.Name, &t.Description, &t.Data, &t.Paused, &t.Created); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, rows.Err()
+}
+
+// PauseTarget pauses or resumes the scheduling of a registered target
+func (d *PostgresService) PauseTarget(name string, paused bool) error {
+	tag, err := d.DB.Exec(
+		context.Background(),
+		"UPDATE pipers_targets SET paused = $2, resumed_at = CASE WHEN paused AND NOT $2 THEN NOW() ELSE resumed_at END WHERE name = $1",
+		name, paused,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("target %v not found", name)
+	}
+	return nil
+}
+
+// PausePipe pauses or resumes the scheduling and processing of a pipe
+func (d *PostgresService) PausePipe(name string, paused bool) error {
+	_, err := d.DB.Exec(
+		context.Background(),
+		`INSERT INTO pipers_pipes (name, paused) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET paused = EXCLUDED.paused, updated_at = NOW(),
+		resumed_at = CASE WHEN pipers_pipes.paused AND NOT EXCLUDED.paused THEN NOW() ELSE pipers_pipes.resumed_at END`,
+		name, paused,
+	)
+	return err
+}
+
+// RetrievePaused returns the names of all paused pipes and targets
+func (d *PostgresService) RetrievePaused() (Paused, error) {
+	paused := newPaused()
+
+	for query, names := range map[string]map[string]bool{
+		"SELECT name FROM pipers_pipes WHERE paused":   paused.Pipes,
+		"SELECT name FROM pipers_targets WHERE paused": paused.Targets,
+	} {
+		rows, err := d.DB.Query(context.Background(), query)
+		if err != nil {
+			return paused, err
+		}
+
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return paused, err
+			}
+			names[name] = true
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return paused, err
+		}
+	}
+
+	return paused, nil
+}
+
+// RemoveTarget deletes a target with all its records of the passed data
+// tables and its rows of TARGET_TABLES. It returns the deleted rows by table.
+func (d *PostgresService) RemoveTarget(name string, tables []string) (map[string]int64, error) {
+	ctx := context.Background()
+	deleted := make(map[string]int64)
+
+	tx, err := d.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, "DELETE FROM pipers_targets WHERE name = $1", name)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("target %v not found", name)
+	}
+
+	for _, table := range append(append([]string{}, tables...), TARGET_TABLES...) {
+		tag, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %v WHERE target = $1", table), name)
+		if err != nil {
+			return nil, fmt.Errorf("deleting from %v failed: %v", table, err)
+		}
+		deleted[table] = tag.RowsAffected()
+	}
+
+	return deleted, tx.Commit(ctx)
+}
+
+// CountRecords returns the number of records of a data table by target
+func (d *PostgresService) CountRecords(table string) (map[string]int64, error) {
+	rows, err := d.DB.Query(context.Background(), fmt.Sprintf("SELECT target, COUNT(*) FROM %v GROUP BY target", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var target string
+		var n int64
+		if err := rows.Scan(&target, &n); err != nil {
+			return nil, err
+		}
+		counts[target] = n
+	}
+
+	return counts, rows.Err()
+}
+
+// RetrieveBlocked returns all excluded assets of the exclusion tables. If
+// a table is keyed by target, only the exclusions of the passed target apply.
+func (d *PostgresService) RetrieveBlocked(target string) ([]string, error) {
+	var parts []string
+	var args []interface{}
+
+	for _, table := range d.Scope.exclusionTables() {
+		part := fmt.Sprintf("SELECT asset FROM %v WHERE exclude = true", table)
+		if d.Layout.KeyedByTarget(table) {
+			args = append(args, target)
+			part += fmt.Sprintf(" AND target = $%v", len(args))
+		}
+		parts = append(parts, part)
+	}
+
+	sql := strings.Join(parts, " UNION ")
 
 	var blocked []string
-	rows, err := d.DB.Query(context.Background(), "SELECT DISTINCT asset FROM domains WHERE exclude = true")
+	rows, err := d.DB.Query(context.Background(), sql, args...)
 	if err != nil {
 		return blocked, err
 	}
@@ -243,10 +661,11 @@ func (d *PostgresService) RetrieveBlocked() ([]string, error) {
 	return blocked, err
 }
 
-func (d *PostgresService) Save(table, pipe, id string, data Data, result map[string]interface{}) (bool, error) {
+func (d *PostgresService) Save(table, pipe, id string, data Data, result map[string]interface{}, opts SaveOptions) (SaveResult, error) {
+	var res SaveResult
 
 	sql := fmt.Sprintf(`
-		INSERT INTO %v (id, asset, target, pipe, data) VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO %v (id, asset, target, pipe, data, asset_type) VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT DO NOTHING;
 	`, table)
 
@@ -258,23 +677,372 @@ func (d *PostgresService) Save(table, pipe, id string, data Data, result map[str
 
 	delete(result, "asset")
 
-	upsert, err := d.DB.Exec(context.Background(), sql, id, asset, data.Target, pipe, result)
+	ctx := context.Background()
+
+	tx, err := d.DB.Begin(ctx)
 	if err != nil {
-		return false, err
+		return res, err
+	}
+	defer tx.Rollback(ctx)
+
+	upsert, err := tx.Exec(ctx, sql, id, asset, data.Target, pipe, result, opts.AssetType)
+	if err != nil {
+		return res, err
+	}
+
+	if s := opts.Source; s != nil {
+		_, err := tx.Exec(
+			ctx,
+			`INSERT INTO pipers_lineage (tbl, target, ident, source_tbl, source_ident, pipe, task_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`,
+			table, data.Target, id, s.Table, s.Ident, pipe, s.TaskId,
+		)
+		if err != nil {
+			return res, err
+		}
 	}
 
 	if upsert.RowsAffected() == 1 {
-		return true, nil
+		// targets of new records are registered, so they are known to
+		// as_file pipes and target management
+		if _, err := tx.Exec(ctx, "INSERT INTO pipers_targets (name) VALUES ($1) ON CONFLICT DO NOTHING", data.Target); err != nil {
+			return res, err
+		}
+
+		res.Inserted = true
+		return res, tx.Commit(ctx)
+	}
+
+	where, whereArgs := d.Layout.recordWhere(table, id, data.Target, func(n int) string {
+		return fmt.Sprintf("$%v", n)
+	})
+
+	// compare tracked fields with the stored version
+	var stored map[string]interface{}
+	var active bool
+	err = tx.QueryRow(ctx, fmt.Sprintf("SELECT data, active FROM %v WHERE %v FOR UPDATE", table, where), whereArgs...).Scan(&stored, &active)
+	if err != nil {
+		return res, err
+	}
+
+	res.Reactivated = !active
+	res.Changes = diffFields(stored, result, opts.Track)
+
+	if len(res.Changes) > 0 {
+		if _, err := tx.Exec(ctx, "INSERT INTO pipers_history (tbl, target, ident, pipe, data) VALUES ($1, $2, $3, $4, $5)", table, data.Target, id, pipe, stored); err != nil {
+			return res, err
+		}
+
+		stored = result
+		res.Updated = true
+	}
+
+	// the record was seen again
+	where, whereArgs = d.Layout.recordWhere(table, id, data.Target, func(n int) string {
+		return fmt.Sprintf("$%v", n+2)
+	})
+
+	update := fmt.Sprintf("UPDATE %v SET data = $1, asset_type = COALESCE(NULLIF($2, ''), asset_type), active = true, last_seen = NOW() WHERE %v", table, where)
+	if _, err := tx.Exec(ctx, update, append([]interface{}{stored, opts.AssetType}, whereArgs...)...); err != nil {
+		return res, err
+	}
+
+	return res, tx.Commit(ctx)
+}
+
+// Lineage returns the edges of the ancestry of a record, or of its
+// descendants. An empty target matches records of all targets.
+func (d *PostgresService) Lineage(table, target, ident string, descendants bool) ([]Lineage, error) {
+	sql := fmt.Sprintf(lineageWalk(descendants), "$1", "$2", "$3")
+
+	rows, err := d.DB.Query(context.Background(), sql, table, ident, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edges []Lineage
+	for rows.Next() {
+		var l Lineage
+		if err := rows.Scan(&l.Table, &l.Target, &l.Ident, &l.SourceTable, &l.SourceIdent, &l.Pipe, &l.TaskId, &l.Created); err != nil {
+			return nil, err
+		}
+		edges = append(edges, l)
+	}
+
+	return edges, rows.Err()
+}
+
+// Exclude marks a record as excluded and cascades the exclusion along
+// the lineage to all records produced from it. Cascaded exclusions are
+// kept in pipers_exclusions so Include can reverse them, records which
+// were excluded before are not touched. With opts.Delete descendants
+// are deleted instead. The affected descendants are returned.
+func (d *PostgresService) Exclude(table, target, ident string, opts ExcludeOptions) ([]Record, error) {
+	edges, err := d.Lineage(table, target, ident, true)
+	if err != nil {
+		return nil, err
+	}
+
+	covered, err := d.coveredEdges(table, target, ident)
+	if err != nil {
+		return nil, err
+	}
+	edges = append(edges, covered...)
+
+	ctx := context.Background()
+	dollar := func(n int) string { return fmt.Sprintf("$%v", n) }
+
+	tx, err := d.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	where, args := rootWhere(ident, target, dollar)
+	tag, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %v SET exclude = true WHERE %v", table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("record %v not found in %v", ident, table)
+	}
+
+	var affected []Record
+	for _, r := range descendants(edges, Record{Table: table, Target: target, Ident: ident}) {
+		where, args := d.Layout.recordWhere(r.Table, r.Ident, r.Target, dollar)
+
+		if opts.Delete {
+			tag, err := tx.Exec(ctx, fmt.Sprintf("DELETE FROM %v WHERE %v", r.Table, where), args...)
+			if err != nil {
+				return nil, err
+			}
+			if tag.RowsAffected() > 0 {
+				affected = append(affected, r)
+			}
+
+			_, err = tx.Exec(ctx, "DELETE FROM pipers_exclusions WHERE tbl = $1 AND target = $2 AND ident = $3", r.Table, r.Target, r.Ident)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var excluded, cascaded bool
+		err := tx.QueryRow(ctx, fmt.Sprintf("SELECT COALESCE(exclude, false) FROM %v WHERE %v", r.Table, where), args...).Scan(&excluded)
+		if err == pgx.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		err = tx.QueryRow(
+			ctx,
+			"SELECT EXISTS (SELECT 1 FROM pipers_exclusions WHERE tbl = $1 AND target = $2 AND ident = $3)",
+			r.Table, r.Target, r.Ident,
+		).Scan(&cascaded)
+		if err != nil {
+			return nil, err
+		}
+
+		// excluded independently of a cascade
+		if excluded && !cascaded {
+			continue
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`INSERT INTO pipers_exclusions (tbl, target, ident, root_tbl, root_ident)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`,
+			r.Table, r.Target, r.Ident, table, ident,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if !excluded {
+			if _, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %v SET exclude = true WHERE %v", r.Table, where), args...); err != nil {
+				return nil, err
+			}
+			affected = append(affected, r)
+		}
 	}
 
-	return false, nil
+	return affected, tx.Commit(ctx)
 }
 
-func (d *PostgresService) SaveAlert(pipe string, id, msg, alertType string) error {
+// coveredEdges returns the edges of as_file outputs the exclusion of a
+// record cascades to, see coveredEdges
+func (d *PostgresService) coveredEdges(table, target, ident string) ([]Lineage, error) {
+	dollar := func(n int) string { return fmt.Sprintf("$%v", n) }
+
+	where, args := rootWhere(ident, target, dollar)
+	roots, err := d.query(fmt.Sprintf("SELECT id, asset, target, data, asset_type FROM %v WHERE %v", table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	asset := func(r Record) string {
+		where, args := d.Layout.recordWhere(r.Table, r.Ident, r.Target, dollar)
+
+		var a string
+		d.DB.QueryRow(context.Background(), fmt.Sprintf("SELECT asset FROM %v WHERE %v", r.Table, where), args...).Scan(&a)
+		return a
+	}
+
+	var edges []Lineage
+	for _, root := range roots {
+		covered, err := coveredEdges(d, Record{Table: table, Target: root.Target, Ident: ident}, root.Asset, asset)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, covered...)
+	}
+
+	return edges, nil
+}
+
+// Include reverses Exclude. Descendants are included again unless
+// the exclusion of another record cascaded to them as well.
+func (d *PostgresService) Include(table, target, ident string) ([]Record, error) {
+	ctx := context.Background()
+	dollar := func(n int) string { return fmt.Sprintf("$%v", n) }
+
+	tx, err := d.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
 
-	sql := `INSERT INTO pipers_alerts (type, pipe, ident, message) VALUES ($1, $2, $3, $4)`
+	where, args := rootWhere(ident, target, dollar)
+	tag, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %v SET exclude = false WHERE %v", table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, fmt.Errorf("record %v not found in %v", ident, table)
+	}
 
-	_, err := d.DB.Exec(context.Background(), sql, alertType, pipe, id, msg)
+	rows, err := tx.Query(
+		ctx,
+		`DELETE FROM pipers_exclusions WHERE root_tbl = $1 AND root_ident = $2 AND ($3 = '' OR target = $3)
+		RETURNING tbl, target, ident`,
+		table, ident, target,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var cascaded []Record
+	for rows.Next() {
+		var r Record
+		if err := rows.Scan(&r.Table, &r.Target, &r.Ident); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cascaded = append(cascaded, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var affected []Record
+	for _, r := range cascaded {
+		var remaining bool
+		err := tx.QueryRow(
+			ctx,
+			"SELECT EXISTS (SELECT 1 FROM pipers_exclusions WHERE tbl = $1 AND target = $2 AND ident = $3)",
+			r.Table, r.Target, r.Ident,
+		).Scan(&remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		if remaining {
+			continue
+		}
+
+		where, args := d.Layout.recordWhere(r.Table, r.Ident, r.Target, dollar)
+		if _, err := tx.Exec(ctx, fmt.Sprintf("UPDATE %v SET exclude = false WHERE %v", r.Table, where), args...); err != nil {
+			return nil, err
+		}
+		affected = append(affected, r)
+	}
+
+	return affected, tx.Commit(ctx)
+}
+
+// Export streams the rows of a data table, of pipers_alerts or of
+// pipers_tasks to fn, ordered by creation. An error of fn stops it.
+func (d *PostgresService) Export(table string, q ExportQuery, fn func(Row) error) error {
+	sql, args, err := exportQuery(table, q, &filterCompiler{dollar: true})
+	if err != nil {
+		return err
+	}
+
+	rows, err := d.DB.Query(context.Background(), sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row Row
+
+		switch table {
+		case "pipers_alerts":
+			var a Alert
+			if err := rows.Scan(&a.Type, &a.Pipe, &a.Target, &a.Ident, &a.Message, &a.Created); err != nil {
+				return err
+			}
+			row = alertRow(a)
+		case "pipers_tasks":
+			var t Task
+			if err := rows.Scan(&t.Pipe, &t.Table, &t.Target, &t.Ident, &t.TaskId, &t.Note, &t.Created); err != nil {
+				return err
+			}
+			row = taskRow(t)
+		default:
+			var data Data
+			var exclude, active bool
+			var created time.Time
+			var lastSeen *time.Time
+			if err := rows.Scan(&data.Id, &data.Asset, &data.AssetType, &data.Target, &data.Pipe, &exclude, &active, &created, &lastSeen, &data.Data); err != nil {
+				return err
+			}
+			row = recordRow(data, exclude, active, created, lastSeen)
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// MarkRemoved flags all active records of a pipe which were not seen
+// within the passed duration as inactive and returns them. Records of
+// paused targets and pipes are kept, they are not refreshed while
+// paused. After a resume the duration starts again.
+func (d *PostgresService) MarkRemoved(table, pipe string, after time.Duration) ([]Data, error) {
+	sql := fmt.Sprintf(`
+		UPDATE %v SET active = false
+		WHERE pipe = $1 AND active = true AND COALESCE(last_seen, created_at) < NOW() - $2::interval
+		AND target NOT IN (SELECT name FROM pipers_targets WHERE paused OR resumed_at > NOW() - $2::interval)
+		AND NOT EXISTS (SELECT 1 FROM pipers_pipes WHERE name = $1 AND (paused OR resumed_at > NOW() - $2::interval))
+		RETURNING id, asset, target, data, asset_type
+	`, table)
+
+	return d.query(sql, pipe, after)
+}
+
+func (d *PostgresService) SaveAlert(pipe, target, id, msg, alertType string) error {
+
+	sql := `INSERT INTO pipers_alerts (type, pipe, target, ident, message) VALUES ($1, $2, $3, $4, $5)`
+
+	_, err := d.DB.Exec(context.Background(), sql, alertType, pipe, target, id, msg)
 	if err != nil {
 		return err
 	}
@@ -286,3 +1054,34 @@ func (d *PostgresService) SaveAlert(pipe string, id, msg, alertType string) erro
 
 	return nil
 }
+
+// Prune deletes rows of a table which are older than maxAge. Records
+// of data tables are pruned by last_seen, falling back to created_at,
+// and can be limited to a target.
+func (d *PostgresService) Prune(table, target string, maxAge time.Duration, dryRun bool) (int64, error) {
+	column := "COALESCE(last_seen, created_at)"
+	if isEssential(table) {
+		column = "created_at"
+	}
+
+	where := fmt.Sprintf("%v < NOW() - $1::interval", column)
+	args := []interface{}{maxAge}
+
+	if target != "" {
+		where += " AND target = $2"
+		args = append(args, target)
+	}
+
+	if dryRun {
+		var n int64
+		err := d.DB.QueryRow(context.Background(), fmt.Sprintf("SELECT COUNT(*) FROM %v WHERE %v", table, where), args...).Scan(&n)
+		return n, err
+	}
+
+	deleted, err := d.DB.Exec(context.Background(), fmt.Sprintf("DELETE FROM %v WHERE %v", table, where), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted.RowsAffected(), nil
+}
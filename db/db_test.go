@@ -3,11 +3,9 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
 	"testing"
 	"time"
 
-	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -15,14 +13,15 @@ const DB_URI = "host=localhost user=postgres password=postgres dbname=pipers_tes
 
 var TABLES = []string{"domains", "services"}
 
-func testConnect() (*pgxpool.Pool, func()) {
-
+// testConnect connects to the test database and recreates all tables.
+// Postgres tests are skipped if the database is not reachable.
+func testConnect(t *testing.T) (*pgxpool.Pool, func()) {
 	db, err := InitDb(DB_URI)
 	if err != nil {
-		panic(err)
+		t.Skipf("postgres not available: %v", err)
 	}
 
-	for _, table := range append(TABLES, "pipers_alerts", "pipers_tasks") {
+	for _, table := range append(TABLES, "pipers_alerts", "pipers_tasks", "pipers_history", "pipers_last_run", "pipers_watermarks", "pipers_targets", "pipers_lineage", "pipers_exclusions", "pipers_pipes", "pipers_schema_version") {
 		_, err = db.Exec(context.Background(), fmt.Sprintf("DROP TABLE IF EXISTS %v", table))
 		if err != nil {
 			panic(err)
@@ -43,7 +42,7 @@ func TestSchemaCreation(t *testing.T) {
 	// make DB available global
 	db, err := InitDb(DB_URI)
 	if err != nil {
-		log.Fatal(err)
+		t.Skipf("postgres not available: %v", err)
 	}
 	defer db.Close()
 
@@ -60,7 +59,7 @@ func TestSchemaCreation(t *testing.T) {
 	}
 }
 func TestShouldRun(t *testing.T) {
-	db, _ := testConnect()
+	db, _ := testConnect(t)
 	// defer teardown()
 
 	ds := &PostgresService{DB: db}
@@ -83,15 +82,12 @@ func TestShouldRun(t *testing.T) {
 	}
 
 	t.Run("retrieves asset", func(t *testing.T) {
-		rows, err := ds.Retrieve("domains", "http_detect", filter, threshold, time.Second*0)
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, threshold), time.Second*0)
 		if err != nil {
 			t.Error(err)
 		}
 
-		count := 0
-		for rows.Next() {
-			count++
-		}
+		count := len(rows)
 
 		if count != 1 {
 			t.Errorf("want = 1, got = %v", count)
@@ -99,15 +95,12 @@ func TestShouldRun(t *testing.T) {
 	})
 
 	t.Run("retrieves asset with interval", func(t *testing.T) {
-		rows, err := ds.Retrieve("domains", "http_detect", filter, threshold, time.Hour*48)
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, threshold), time.Hour*48)
 		if err != nil {
 			t.Error(err)
 		}
 
-		count := 0
-		for rows.Next() {
-			count++
-		}
+		count := len(rows)
 
 		if count != 1 {
 			t.Errorf("want = 3, got = %v", count)
@@ -117,16 +110,18 @@ func TestShouldRun(t *testing.T) {
 	t.Run("should not retrieve asset with task in the past", func(t *testing.T) {
 
 		if err := ds.AddTask(Task{
-			Pipe:  "http_detect",
-			Ident: ident,
+			Pipe:   "http_detect",
+			Table:  "domains",
+			Target: target,
+			Ident:  ident,
 		}); err != nil {
 			t.Error(err)
 		}
 		defer func() {
-			db.Exec(context.Background(), "DELETE FROM pipers_tasks")
+			db.Exec(context.Background(), "DELETE FROM pipers_last_run")
 		}()
 
-		rows, err := ds.Retrieve("domains", "http_detect", filter, threshold, time.Minute*1)
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, threshold), time.Minute*1)
 		if err != nil {
 			t.Error(err)
 		}
@@ -143,15 +138,16 @@ func TestShouldRun(t *testing.T) {
 
 		if err := ds.AddTask(Task{
 			Pipe:  "http_foobar",
+			Table: "domains",
 			Ident: ident,
 		}); err != nil {
 			t.Error(err)
 		}
 		defer func() {
-			db.Exec(context.Background(), "DELETE FROM pipers_tasks")
+			db.Exec(context.Background(), "DELETE FROM pipers_last_run")
 		}()
 
-		rows, err := ds.Retrieve("domains", "http_detect", filter, threshold, time.Minute*1)
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, threshold), time.Minute*1)
 		if err != nil {
 			t.Error(err)
 		}
@@ -168,15 +164,16 @@ func TestShouldRun(t *testing.T) {
 
 		if err := ds.AddTask(Task{
 			Pipe:  "http_detect",
+			Table: "domains",
 			Ident: ident + "a",
 		}); err != nil {
 			t.Error(err)
 		}
 		defer func() {
-			db.Exec(context.Background(), "DELETE FROM pipers_tasks")
+			db.Exec(context.Background(), "DELETE FROM pipers_last_run")
 		}()
 
-		rows, err := ds.Retrieve("domains", "http_detect", filter, threshold, time.Minute*1)
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, threshold), time.Minute*1)
 		if err != nil {
 			t.Error(err)
 		}
@@ -191,7 +188,7 @@ func TestShouldRun(t *testing.T) {
 }
 
 func TestShouldRunInputFilter(t *testing.T) {
-	db, _ := testConnect()
+	db, _ := testConnect(t)
 	// defer teardown()
 
 	ds := &PostgresService{DB: db}
@@ -218,15 +215,12 @@ func TestShouldRunInputFilter(t *testing.T) {
 		f := map[string]string{
 			"scope": "true",
 		}
-		rows, err := ds.Retrieve("domains", "http_detect", f, threshold, time.Second*0)
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(f, threshold), time.Second*0)
 		if err != nil {
 			t.Error(err)
 		}
 
-		count := 0
-		for rows.Next() {
-			count++
-		}
+		count := len(rows)
 
 		if count != 1 {
 			t.Errorf("want = 1, got = %v", count)
@@ -237,15 +231,12 @@ func TestShouldRunInputFilter(t *testing.T) {
 		thresh := map[string]string{
 			"score": "101",
 		}
-		rows, err := ds.Retrieve("domains", "http_detect", filter, thresh, time.Second*0)
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, thresh), time.Second*0)
 		if err != nil {
 			t.Error(err)
 		}
 
-		count := 0
-		for rows.Next() {
-			count++
-		}
+		count := len(rows)
 
 		if count != 1 {
 			t.Errorf("want = 1, got = %v", count)
@@ -254,10 +245,106 @@ func TestShouldRunInputFilter(t *testing.T) {
 
 }
 
-func testCountRows(r pgx.Rows) int {
-	count := 0
-	for r.Next() {
-		count++
+func testCountRows(r []Data) int {
+	return len(r)
+}
+
+// testWithoutLastSeen checks that records without last_seen, like rows
+// saved before the column existed, expire by created_at
+func testWithoutLastSeen(t *testing.T, ds DataService, exec func(query string) error) {
+	if err := exec("INSERT INTO domains (id, asset, target, pipe, created_at, last_seen) VALUES ('old.com', 'old.com', 'example', 'manual', '2000-01-01 00:00:00', NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Save("domains", "manual", "new.com", Data{Asset: "new.com", Target: "example"}, map[string]interface{}{}, SaveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := ds.Prune("domains", "", time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("want = 1 record to be pruned, got = %v", n)
+	}
+
+	removed, err := ds.MarkRemoved("domains", "manual", time.Hour)
+	if err != nil {
+		t.Fatal(err)
 	}
-	return count
+	if len(removed) != 1 || removed[0].Id != "old.com" {
+		t.Errorf("want old.com to be removed, got %+v", removed)
+	}
+}
+
+func TestWithoutLastSeen(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testWithoutLastSeen(t, &PostgresService{DB: db}, func(query string) error {
+		_, err := db.Exec(context.Background(), query)
+		return err
+	})
+}
+
+func TestPostgresIncrement(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testIncrement(t, &PostgresService{DB: db})
+}
+
+func TestPostgresSaveRegistersTarget(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testSaveRegistersTarget(t, &PostgresService{DB: db})
+}
+
+func TestPostgresLineage(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testLineage(t, &PostgresService{DB: db})
+}
+
+func TestPostgresExclusions(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testExclusions(t, &PostgresService{DB: db})
+}
+
+func TestPostgresFileExclusions(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testFileExclusions(t, &PostgresService{DB: db})
+}
+
+func TestPostgresExport(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testExport(t, &PostgresService{DB: db})
+}
+
+func TestPostgresTargetManagement(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testTargetManagement(t, &PostgresService{DB: db})
+}
+
+func TestPostgresPaused(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testPaused(t, &PostgresService{DB: db})
+}
+
+func TestPostgresResumed(t *testing.T) {
+	db, _ := testConnect(t)
+	defer db.Close()
+
+	testResumed(t, &PostgresService{DB: db})
 }
@@ -0,0 +1,31 @@
+package db
+
+// DUE_BATCH_SIZE is the number of idents checked with a single query
+const DUE_BATCH_SIZE = 500
+
+// batches splits idents into chunks of at most size
+func batches(idents []string, size int) [][]string {
+	var result [][]string
+	for len(idents) > size {
+		result = append(result, idents[:size])
+		idents = idents[size:]
+	}
+
+	if len(idents) > 0 {
+		result = append(result, idents)
+	}
+
+	return result
+}
+
+// withoutIdents returns all idents which are not in the passed set,
+// keeping their order
+func withoutIdents(idents []string, remove map[string]struct{}) []string {
+	result := []string{}
+	for _, ident := range idents {
+		if _, ok := remove[ident]; !ok {
+			result = append(result, ident)
+		}
+	}
+	return result
+}
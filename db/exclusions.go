@@ -0,0 +1,98 @@
+package db
+
+import "github.com/rverton/pipers/scope"
+
+// Record identifies a record of a data table
+type Record struct {
+	Table  string `json:"table"`
+	Target string `json:"target"`
+	Ident  string `json:"ident"`
+}
+
+// ExcludeOptions configure how descendants of an excluded record
+// are handled
+type ExcludeOptions struct {
+	// delete descendants instead of excluding them, this can not
+	// be reversed
+	Delete bool
+}
+
+// rootWhere selects the record an exclusion starts at, an empty target
+// matches records of all targets
+func rootWhere(ident, target string, placeholder func(int) string) (string, []interface{}) {
+	if target == "" {
+		return "id = " + placeholder(1), []interface{}{ident}
+	}
+	return "id = " + placeholder(1) + " AND target = " + placeholder(2), []interface{}{ident, target}
+}
+
+// descendants returns the distinct records of lineage edges leading
+// away from a root, without the root itself
+func descendants(edges []Lineage, root Record) []Record {
+	seen := make(map[Record]struct{})
+	var records []Record
+
+	for _, e := range edges {
+		r := Record{Table: e.Table, Target: e.Target, Ident: e.Ident}
+
+		if r.Table == root.Table && r.Ident == root.Ident && (root.Target == "" || r.Target == root.Target) {
+			continue
+		}
+
+		if _, ok := seen[r]; !ok {
+			seen[r] = struct{}{}
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+// lineageWalker walks the lineage of records, all backends implement it
+type lineageWalker interface {
+	Lineage(table, target, ident string, descendants bool) ([]Lineage, error)
+}
+
+// coveredEdges returns the edges of records produced by as_file pipes
+// which are linked to the whole input of the target of root, see
+// pipe.Source. Records whose asset is covered by the asset of root are
+// cascaded to, like records produced from root, with their descendants.
+func coveredEdges(ds lineageWalker, root Record, rootAsset string, asset func(Record) string) ([]Lineage, error) {
+	if rootAsset == "" {
+		return nil, nil
+	}
+
+	walk, err := ds.Lineage("target:"+root.Table, root.Target, root.Target, true)
+	if err != nil {
+		return nil, err
+	}
+
+	reached := make(map[Record]bool)
+	added := make([]bool, len(walk))
+	var edges []Lineage
+
+	// repeat until no edge is added, as edges are not ordered by the walk
+	for changed := true; changed; {
+		changed = false
+
+		for i, e := range walk {
+			if added[i] {
+				continue
+			}
+
+			r := Record{Table: e.Table, Target: e.Target, Ident: e.Ident}
+			if e.SourceTable == "target:"+root.Table && e.SourceIdent == root.Target {
+				if !scope.Covers(rootAsset, asset(r)) {
+					continue
+				}
+			} else if !reached[Record{Table: e.SourceTable, Target: e.Target, Ident: e.SourceIdent}] {
+				continue
+			}
+
+			reached[r], added[i], changed = true, true, true
+			edges = append(edges, e)
+		}
+	}
+
+	return edges, nil
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testExclusions(t *testing.T, ds DataService) {
+	save := func(table, id, sourceTable, sourceIdent string) {
+		var src *Source
+		if sourceIdent != "" {
+			src = &Source{Table: sourceTable, Ident: sourceIdent}
+		}
+
+		data := Data{Asset: id, Target: "example"}
+		if _, err := ds.Save(table, "p", id, data, map[string]interface{}{}, SaveOptions{Source: src}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save("domains", "example.com", "", "")
+	save("domains", "a.example.com", "domains", "example.com")
+	save("domains", "b.example.com", "domains", "example.com")
+	save("domains", "other.com", "", "")
+	save("services", "https://a.example.com", "domains", "a.example.com")
+	save("services", "https://a.example.com", "domains", "other.com")
+	save("services", "https://b.example.com", "domains", "b.example.com")
+
+	retrieve := func(table string) string {
+		rows, err := ds.Retrieve(table, "p", FieldsFilter(nil, nil), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, r := range rows {
+			ids = append(ids, r.Id)
+		}
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+
+	idents := func(records []Record) string {
+		var s []string
+		for _, r := range records {
+			s = append(s, r.Table+":"+r.Ident)
+		}
+		sort.Strings(s)
+		return strings.Join(s, ",")
+	}
+
+	// b.example.com was excluded before, it is not touched by a cascade
+	if _, err := ds.Exclude("domains", "example", "b.example.com", ExcludeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	affected, err := ds.Exclude("domains", "example", "example.com", ExcludeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := idents(affected), "domains:a.example.com,services:https://a.example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+
+	if got := retrieve("domains"); got != "other.com" {
+		t.Errorf("want = other.com, got = %v", got)
+	}
+	if got := retrieve("services"); got != "" {
+		t.Errorf("want no services, got = %v", got)
+	}
+
+	// excluding another root of the same descendant
+	if _, err := ds.Exclude("domains", "", "other.com", ExcludeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	affected, err = ds.Include("domains", "example", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := idents(affected), "domains:a.example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+
+	if got, want := retrieve("domains"), "a.example.com,example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+	if got := retrieve("services"); got != "" {
+		t.Errorf("want services still excluded by other.com, got = %v", got)
+	}
+
+	if _, err := ds.Include("domains", "", "other.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := retrieve("services"), "https://a.example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+
+	// deleting descendants
+	affected, err = ds.Exclude("domains", "example", "b.example.com", ExcludeOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := idents(affected), "services:https://b.example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+	if got, want := retrieve("services"), "https://a.example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+
+	if _, err := ds.Exclude("domains", "example", "missing.com", ExcludeOptions{}); err == nil {
+		t.Errorf("want error for missing record")
+	}
+}
+
+// testFileExclusions checks the cascade to outputs of as_file pipes,
+// which are linked to the whole input of their target
+func testFileExclusions(t *testing.T, ds DataService) {
+	save := func(table, id string, src *Source) {
+		data := Data{Asset: id, Target: "example"}
+		if _, err := ds.Save(table, "p", id, data, map[string]interface{}{}, SaveOptions{Source: src}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := &Source{Table: "target:domains", Ident: "example"}
+
+	save("domains", "example.com", nil)
+	save("domains", "www.example.com", file)
+	save("domains", "example.org", file)
+	save("services", "https://www.example.com", &Source{Table: "domains", Ident: "www.example.com"})
+
+	idents := func(records []Record) string {
+		var s []string
+		for _, r := range records {
+			s = append(s, r.Table+":"+r.Ident)
+		}
+		sort.Strings(s)
+		return strings.Join(s, ",")
+	}
+
+	affected, err := ds.Exclude("domains", "", "example.com", ExcludeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := idents(affected), "domains:www.example.com,services:https://www.example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+
+	affected, err = ds.Include("domains", "", "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := idents(affected), "domains:www.example.com,services:https://www.example.com"; got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+}
+
+func TestSqliteFileExclusions(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testFileExclusions(t, ds)
+}
+
+func TestMemoryFileExclusions(t *testing.T) {
+	testFileExclusions(t, &MemoryService{})
+}
+
+func TestSqliteExclusions(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testExclusions(t, ds)
+}
+
+func TestMemoryExclusions(t *testing.T) {
+	testExclusions(t, &MemoryService{})
+}
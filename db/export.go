@@ -0,0 +1,155 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Row is an exported row, keyed by the names of ExportColumns
+type Row map[string]interface{}
+
+// ExportQuery selects the rows of an export. Filter matches data fields
+// and is only supported for data tables.
+type ExportQuery struct {
+	Target string
+	Pipe   string
+	Since  time.Time // created at or after, if set
+	Until  time.Time // created before, if set
+	Filter Filter
+
+	// only records passed to pipes, which are neither excluded nor
+	// removed, only supported for data tables
+	Active bool
+}
+
+var (
+	ALERT_COLUMNS  = []string{"type", "pipe", "target", "ident", "message", "created_at"}
+	TASK_COLUMNS   = []string{"pipe", "table", "target", "ident", "task_id", "note", "created_at"}
+	RECORD_COLUMNS = []string{"id", "asset", "asset_type", "target", "pipe", "exclude", "active", "created_at", "last_seen", "data"}
+)
+
+var tableNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ExportColumns returns the columns of the exported rows of a table
+func ExportColumns(table string) []string {
+	switch table {
+	case "pipers_alerts":
+		return ALERT_COLUMNS
+	case "pipers_tasks":
+		return TASK_COLUMNS
+	}
+	return RECORD_COLUMNS
+}
+
+// exportQuery builds the query of an export, ordered by creation
+func exportQuery(table string, q ExportQuery, c *filterCompiler) (string, []interface{}, error) {
+	if !tableNameRegexp.MatchString(table) {
+		return "", nil, fmt.Errorf("invalid table name %q", table)
+	}
+
+	var columns string
+	switch table {
+	case "pipers_alerts":
+		columns = "type, pipe, target, ident, COALESCE(message, ''), created_at"
+	case "pipers_tasks":
+		columns = "pipe, COALESCE(tbl, ''), COALESCE(target, ''), ident, COALESCE(task_id, ''), COALESCE(note, ''), created_at"
+	default:
+		if strings.HasPrefix(table, "pipers_") {
+			return "", nil, fmt.Errorf("table %v can not be exported", table)
+		}
+		columns = "id, asset, COALESCE(asset_type, ''), target, pipe, COALESCE(exclude, false), COALESCE(active, true), created_at, last_seen, data"
+	}
+
+	var where []string
+	timeArg := func(t time.Time) interface{} {
+		if c.sqlite {
+			return sqliteTime(t)
+		}
+		return t
+	}
+
+	if q.Target != "" {
+		where = append(where, "target = "+c.arg(q.Target))
+	}
+	if q.Pipe != "" {
+		where = append(where, "pipe = "+c.arg(q.Pipe))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= "+c.arg(timeArg(q.Since)))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at < "+c.arg(timeArg(q.Until)))
+	}
+
+	if (!q.Filter.Empty() || q.Active) && ExportColumns(table)[0] != "id" {
+		return "", nil, fmt.Errorf("data filters are only supported for data tables")
+	}
+
+	if q.Active {
+		where = append(where, "COALESCE(exclude, false) = false AND COALESCE(active, true) = true")
+	}
+
+	if !q.Filter.Empty() {
+		// the filter continues the placeholders of the conditions
+		s, _ := q.Filter.compile(c)
+		where = append(where, s)
+	}
+
+	query := fmt.Sprintf("SELECT %v FROM %v", columns, table)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	return query + " ORDER BY created_at, id", c.args, nil
+}
+
+func alertRow(a Alert) Row {
+	return Row{
+		"type":       a.Type,
+		"pipe":       a.Pipe,
+		"target":     a.Target,
+		"ident":      a.Ident,
+		"message":    a.Message,
+		"created_at": a.Created,
+	}
+}
+
+func taskRow(t Task) Row {
+	return Row{
+		"pipe":       t.Pipe,
+		"table":      t.Table,
+		"target":     t.Target,
+		"ident":      t.Ident,
+		"task_id":    t.TaskId,
+		"note":       t.Note,
+		"created_at": t.Created,
+	}
+}
+
+// recordRow returns the row of a data table record, a missing
+// last_seen falls back to created
+func recordRow(data Data, exclude, active bool, created time.Time, lastSeen *time.Time) Row {
+	seen := created
+	if lastSeen != nil {
+		seen = *lastSeen
+	}
+
+	if data.Data == nil {
+		data.Data = map[string]interface{}{}
+	}
+
+	return Row{
+		"id":         data.Id,
+		"asset":      data.Asset,
+		"asset_type": data.AssetType,
+		"target":     data.Target,
+		"pipe":       data.Pipe,
+		"exclude":    exclude,
+		"active":     active,
+		"created_at": created,
+		"last_seen":  seen,
+		"data":       data.Data,
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testExport(t *testing.T, ds DataService) {
+	save := func(id, target, pipe string, data map[string]interface{}) {
+		if _, err := ds.Save("domains", pipe, id, Data{Asset: id, Target: target}, data, SaveOptions{AssetType: "domain"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save("a.example.com", "example", "subfinder", map[string]interface{}{"port": 443})
+	save("b.example.com", "example", "amass", map[string]interface{}{"port": 80})
+	save("other.com", "other", "subfinder", map[string]interface{}{"port": 443})
+
+	ds.SaveAlert("subfinder", "example", "a.example.com", "new a", "CREATED")
+	ds.SaveAlert("subfinder", "other", "other.com", "new other", "CREATED")
+	ds.AddTask(Task{Pipe: "subfinder", Table: "domains", Target: "example", Ident: "example.com", TaskId: "t1"})
+
+	export := func(table string, q ExportQuery) []Row {
+		var rows []Row
+		if err := ds.Export(table, q, func(r Row) error {
+			rows = append(rows, r)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	ids := func(rows []Row, column string) string {
+		var s []string
+		for _, r := range rows {
+			s = append(s, r[column].(string))
+		}
+		return strings.Join(s, ",")
+	}
+
+	where, _ := ParseFilter("port = 443")
+
+	tests := []struct {
+		q    ExportQuery
+		want string
+	}{
+		{ExportQuery{}, "a.example.com,b.example.com,other.com"},
+		{ExportQuery{Target: "example"}, "a.example.com,b.example.com"},
+		{ExportQuery{Pipe: "subfinder"}, "a.example.com,other.com"},
+		{ExportQuery{Filter: where, Target: "example"}, "a.example.com"},
+		{ExportQuery{Since: time.Now().Add(time.Hour)}, ""},
+		{ExportQuery{Until: time.Now().Add(-time.Hour)}, ""},
+		{ExportQuery{Since: time.Now().Add(-time.Hour), Until: time.Now().Add(time.Hour)}, "a.example.com,b.example.com,other.com"},
+	}
+
+	for _, tt := range tests {
+		if got := ids(export("domains", tt.q), "id"); got != tt.want {
+			t.Errorf("%+v: want = %v, got = %v", tt.q, tt.want, got)
+		}
+	}
+
+	if _, err := ds.Exclude("domains", "example", "b.example.com", ExcludeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(export("domains", ExportQuery{Active: true}), "id"); got != "a.example.com,other.com" {
+		t.Errorf("want active records, got = %v", got)
+	}
+
+	row := export("domains", ExportQuery{Target: "other"})[0]
+	if row["asset_type"] != "domain" || row["active"] != true || row["exclude"] != false || jsonText(row["data"].(map[string]interface{})["port"]) != "443" {
+		t.Errorf("unexpected row %+v", row)
+	}
+	if row["created_at"].(time.Time).IsZero() || row["last_seen"].(time.Time).IsZero() {
+		t.Errorf("want created_at and last_seen, got %+v", row)
+	}
+
+	if got := ids(export("pipers_alerts", ExportQuery{Target: "example"}), "message"); got != "new a" {
+		t.Errorf("want alert of example, got = %v", got)
+	}
+
+	tasks := export("pipers_tasks", ExportQuery{Pipe: "subfinder"})
+	if len(tasks) != 1 || tasks[0]["task_id"] != "t1" || tasks[0]["table"] != "domains" {
+		t.Errorf("unexpected tasks %+v", tasks)
+	}
+
+	if err := ds.Export("pipers_alerts", ExportQuery{Filter: where}, func(Row) error { return nil }); err == nil {
+		t.Errorf("want error for data filter on alerts")
+	}
+	if err := ds.Export("pipers_tasks", ExportQuery{Active: true}, func(Row) error { return nil }); err == nil {
+		t.Errorf("want error for active filter on tasks")
+	}
+
+	if err := ds.Export("domains; DROP TABLE domains", ExportQuery{}, func(Row) error { return nil }); err == nil {
+		t.Errorf("want error for invalid table name")
+	}
+}
+
+func TestSqliteExport(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testExport(t, ds)
+}
+
+func TestMemoryExport(t *testing.T) {
+	testExport(t, &MemoryService{})
+}
@@ -0,0 +1,894 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a parsed input filter expression. It is compiled into
+// parameterized SQL by the database backends, so values of an expression
+// are never part of a query. The zero value matches all records.
+//
+// Example:
+//
+//	port in (80, 443) and (title ~ 'admin' or asset ilike '%.dev.%')
+//	and not exists(waf) and created_at >= '2021-06-01'
+//
+// Fields are data keys, except the columns id, asset, asset_type,
+// target and created_at. Data keys named like a column can be prefixed with data.
+// Supported operators are = != < <= > >= ~ (regex) !~ like ilike,
+// in (...) and not in (...), exists(field), and, or, not and brackets.
+// Comparisons with numbers are numeric, all others compare text.
+type Filter struct {
+	root filterNode
+}
+
+// filterRecord is what a filter is matched against in memory
+type filterRecord struct {
+	Data
+	Created time.Time
+}
+
+type filterNode interface {
+	sql(c *filterCompiler) string
+	match(r filterRecord) bool
+}
+
+var filterColumns = map[string]bool{
+	"id":         true,
+	"asset":      true,
+	"asset_type": true,
+	"target":     true,
+	"created_at": true,
+}
+
+var filterTimeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// numbers stored as text are only compared numerically if they match,
+// the pattern avoids ? because squirrel treats it as placeholder
+const filterNumberPattern = `^-{0,1}[0-9]+([.][0-9]+){0,1}$`
+
+var filterNumberRegexp = regexp.MustCompile(filterNumberPattern)
+
+// ParseFilter parses a filter expression, an empty expression
+// matches all records
+func ParseFilter(s string) (Filter, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return Filter{}, err
+	}
+
+	if len(tokens) == 0 {
+		return Filter{}, nil
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	root, err := p.or()
+	if err != nil {
+		return Filter{}, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return Filter{}, fmt.Errorf("unexpected '%v' at position %v", p.peek().text, p.peek().pos)
+	}
+
+	return Filter{root: root}, nil
+}
+
+// FieldsFilter builds a filter from the equality filter and numeric
+// threshold maps of a pipe input
+func FieldsFilter(fields, threshold map[string]string) Filter {
+	var nodes []filterNode
+
+	for _, k := range sortedKeys(fields) {
+		nodes = append(nodes, &filterCompare{
+			field: filterField{key: k},
+			op:    "=",
+			value: filterValue{text: fields[k]},
+		})
+	}
+
+	for _, k := range sortedKeys(threshold) {
+		nodes = append(nodes, &filterCompare{
+			field: filterField{key: k},
+			op:    ">",
+			value: textValue(threshold[k]),
+		})
+	}
+
+	return Filter{root: filterAll(nodes)}
+}
+
+// And combines two filters, both have to match
+func (f Filter) And(other Filter) Filter {
+	var nodes []filterNode
+	for _, n := range []filterNode{f.root, other.root} {
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+
+	return Filter{root: filterAll(nodes)}
+}
+
+// Empty reports whether the filter matches all records
+func (f Filter) Empty() bool {
+	return f.root == nil
+}
+
+func (f Filter) match(r filterRecord) bool {
+	return f.root == nil || f.root.match(r)
+}
+
+// compile returns the filter as SQL condition and its arguments,
+// or an empty string if it matches all records
+func (f Filter) compile(c *filterCompiler) (string, []interface{}) {
+	if f.root == nil {
+		return "", nil
+	}
+
+	return f.root.sql(c), c.args
+}
+
+func filterAll(nodes []filterNode) filterNode {
+	switch len(nodes) {
+	case 0:
+		return nil
+	case 1:
+		return nodes[0]
+	}
+	return &filterAnd{nodes}
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// filterCompiler collects the arguments of a compiled filter
+type filterCompiler struct {
+	sqlite bool
+	alias  string // prefix of columns, like "A."
+	dollar bool   // use $n placeholders instead of ?
+	offset int    // number of $n placeholders before the filter
+	args   []interface{}
+}
+
+func (c *filterCompiler) arg(v interface{}) string {
+	c.args = append(c.args, v)
+	if c.dollar {
+		return fmt.Sprintf("$%v", c.offset+len(c.args))
+	}
+	return "?"
+}
+
+// text returns an expression of the field as text
+func (c *filterCompiler) text(f filterField) string {
+	if f.column != "" {
+		return c.alias + f.column
+	}
+
+	if c.sqlite {
+		// booleans are rendered like postgres' ->> does
+		first, second := c.arg(f.key), c.arg(f.key)
+		return fmt.Sprintf(
+			`(CASE json_type(%[1]vdata, '$."' || %[2]v || '"') WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(%[1]vdata, '$."' || %[3]v || '"') AS TEXT) END)`,
+			c.alias, first, second,
+		)
+	}
+
+	return fmt.Sprintf("(%vdata ->> %v)", c.alias, c.arg(f.key))
+}
+
+// number returns an expression of the field as number, which is null
+// if the field does not contain a number
+func (c *filterCompiler) number(f filterField) string {
+	if c.sqlite {
+		return fmt.Sprintf(
+			"(CASE WHEN %v NOT GLOB '*[^0-9.-]*' AND %v GLOB '*[0-9]*' THEN CAST(%v AS REAL) END)",
+			c.text(f), c.text(f), c.text(f),
+		)
+	}
+
+	return fmt.Sprintf("(CASE WHEN %v ~ '%v' THEN %v::numeric END)", c.text(f), filterNumberPattern, c.text(f))
+}
+
+func (c *filterCompiler) time(t time.Time) string {
+	if c.sqlite {
+		return c.arg(sqliteTime(t))
+	}
+	return c.arg(t.UTC().Format(sqliteTimeFormat)) + "::timestamp"
+}
+
+func (c *filterCompiler) numberValue(v filterValue) string {
+	if c.sqlite {
+		return c.arg(v.number)
+	}
+	return c.arg(v.text) + "::numeric"
+}
+
+type filterAnd struct {
+	nodes []filterNode
+}
+
+func (n *filterAnd) sql(c *filterCompiler) string {
+	var parts []string
+	for _, node := range n.nodes {
+		parts = append(parts, node.sql(c))
+	}
+	return "(" + strings.Join(parts, " AND ") + ")"
+}
+
+func (n *filterAnd) match(r filterRecord) bool {
+	for _, node := range n.nodes {
+		if !node.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+type filterOr struct {
+	nodes []filterNode
+}
+
+func (n *filterOr) sql(c *filterCompiler) string {
+	var parts []string
+	for _, node := range n.nodes {
+		parts = append(parts, node.sql(c))
+	}
+	return "(" + strings.Join(parts, " OR ") + ")"
+}
+
+func (n *filterOr) match(r filterRecord) bool {
+	for _, node := range n.nodes {
+		if node.match(r) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterNot struct {
+	node filterNode
+}
+
+func (n *filterNot) sql(c *filterCompiler) string {
+	return "NOT " + n.node.sql(c)
+}
+
+func (n *filterNot) match(r filterRecord) bool {
+	return !n.node.match(r)
+}
+
+type filterField struct {
+	column string // set for id, asset, asset_type, target and created_at
+	key    string // data key otherwise
+}
+
+func newFilterField(name string) filterField {
+	if strings.HasPrefix(name, "data.") {
+		return filterField{key: strings.TrimPrefix(name, "data.")}
+	}
+
+	if filterColumns[name] {
+		return filterField{column: name}
+	}
+
+	return filterField{key: name}
+}
+
+func (f filterField) String() string {
+	if f.column != "" {
+		return f.column
+	}
+	return f.key
+}
+
+// text returns the field of a record as text, like postgres' ->>
+// a missing or null data field is not present
+func (f filterField) text(r filterRecord) (string, bool) {
+	switch f.column {
+	case "id":
+		return r.Id, true
+	case "asset":
+		return r.Asset, true
+	case "asset_type":
+		return r.AssetType, true
+	case "target":
+		return r.Target, true
+	case "created_at":
+		return r.Created.UTC().Format(sqliteTimeFormat), true
+	}
+
+	v, ok := r.Data.Data[f.key]
+	if !ok || v == nil {
+		return "", false
+	}
+
+	return jsonText(v), true
+}
+
+type filterValue struct {
+	text     string
+	number   float64
+	isNumber bool
+	time     time.Time // for created_at
+}
+
+func textValue(s string) filterValue {
+	v := filterValue{text: s}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		v.number, v.isNumber = n, true
+	}
+	return v
+}
+
+// filterCompare is a comparison of a field with a single value,
+// a comparison with a missing field never matches
+type filterCompare struct {
+	field filterField
+	op    string
+	value filterValue
+	re    *regexp.Regexp // compiled regex or like pattern
+}
+
+func (n *filterCompare) sql(c *filterCompiler) string {
+	var expr string
+
+	switch n.op {
+	case "~", "!~":
+		op := n.op
+		if c.sqlite {
+			op = "REGEXP"
+		}
+		expr = fmt.Sprintf("%v %v %v", c.text(n.field), op, c.arg(n.value.text))
+		if c.sqlite && n.op == "!~" {
+			expr = "NOT (" + expr + ")"
+		}
+	case "like":
+		if c.sqlite {
+			// sqlite's like is case insensitive, glob is not
+			expr = fmt.Sprintf("%v GLOB %v", c.text(n.field), c.arg(likeToGlob(n.value.text)))
+		} else {
+			expr = fmt.Sprintf("%v LIKE %v", c.text(n.field), c.arg(n.value.text))
+		}
+	case "ilike":
+		op := "ILIKE"
+		if c.sqlite {
+			op = "LIKE"
+		}
+		expr = fmt.Sprintf("%v %v %v", c.text(n.field), op, c.arg(n.value.text))
+	default:
+		op := n.op
+		if op == "!=" {
+			op = "<>"
+		}
+
+		// arguments are bound in order, the field comes first
+		switch {
+		case n.field.column == "created_at":
+			left := c.text(n.field)
+			expr = fmt.Sprintf("%v %v %v", left, op, c.time(n.value.time))
+		case n.numeric():
+			left := c.number(n.field)
+			expr = fmt.Sprintf("%v %v %v", left, op, c.numberValue(n.value))
+		default:
+			left := c.text(n.field)
+			expr = fmt.Sprintf("%v %v %v", left, op, c.arg(n.value.text))
+		}
+	}
+
+	return "COALESCE(" + expr + ", false)"
+}
+
+// numeric reports whether the field is compared as number,
+// equality is always compared as text
+func (n *filterCompare) numeric() bool {
+	return n.value.isNumber && n.field.column == "" && n.op != "=" && n.op != "!="
+}
+
+func (n *filterCompare) match(r filterRecord) bool {
+	s, ok := n.field.text(r)
+	if !ok {
+		return false
+	}
+
+	switch n.op {
+	case "~", "like", "ilike":
+		return n.re.MatchString(s)
+	case "!~":
+		return !n.re.MatchString(s)
+	}
+
+	var cmp int
+	switch {
+	case n.field.column == "created_at":
+		cmp = compareTime(r.Created, n.value.time)
+	case n.numeric():
+		if !filterNumberRegexp.MatchString(s) {
+			return false
+		}
+		v, _ := strconv.ParseFloat(s, 64)
+		cmp = compareFloat(v, n.value.number)
+	default:
+		cmp = strings.Compare(s, n.value.text)
+	}
+
+	switch n.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	return false
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// likeRegexp converts a like pattern into a regular expression
+func likeRegexp(pattern string, caseInsensitive bool) *regexp.Regexp {
+	var b strings.Builder
+	if caseInsensitive {
+		b.WriteString("(?i)")
+	}
+
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
+
+// likeToGlob converts a like pattern into a sqlite glob pattern
+func likeToGlob(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString("*")
+		case '_':
+			b.WriteString("?")
+		case '*', '?', '[':
+			b.WriteString("[" + string(r) + "]")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+type filterIn struct {
+	field  filterField
+	values []filterValue
+	negate bool
+}
+
+func (n *filterIn) sql(c *filterCompiler) string {
+	left := c.text(n.field)
+
+	var values []string
+	for _, v := range n.values {
+		values = append(values, c.arg(v.text))
+	}
+
+	op := "IN"
+	if n.negate {
+		op = "NOT IN"
+	}
+
+	return fmt.Sprintf("COALESCE(%v %v (%v), false)", left, op, strings.Join(values, ", "))
+}
+
+func (n *filterIn) match(r filterRecord) bool {
+	s, ok := n.field.text(r)
+	if !ok {
+		return false
+	}
+
+	for _, v := range n.values {
+		if s == v.text {
+			return !n.negate
+		}
+	}
+
+	return n.negate
+}
+
+type filterExists struct {
+	field filterField
+}
+
+func (n *filterExists) sql(c *filterCompiler) string {
+	if c.sqlite {
+		return fmt.Sprintf(`(json_type(%vdata, '$."' || %v || '"') IS NOT NULL)`, c.alias, c.arg(n.field.key))
+	}
+	return fmt.Sprintf("((%vdata -> %v) IS NOT NULL)", c.alias, c.arg(n.field.key))
+}
+
+func (n *filterExists) match(r filterRecord) bool {
+	_, ok := r.Data.Data[n.field.key]
+	return ok
+}
+
+type filterTokenKind int
+
+const (
+	tokenIdent filterTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+var filterSymbols = map[string]bool{
+	"(": true, ")": true, ",": true,
+	"=": true, "!=": true, "<>": true,
+	"<": true, "<=": true, ">": true, ">=": true,
+	"~": true, "!~": true,
+}
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+	pos  int
+}
+
+// keyword reports whether the token is an unquoted keyword
+func (t filterToken) keyword(k string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, k)
+}
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string at position %v", start)
+				}
+				// a doubled quote escapes the quote
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						b.WriteRune(r)
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteRune(runes[i])
+			}
+			i++
+			tokens = append(tokens, filterToken{tokenString, b.String(), start})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, filterToken{tokenNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i++; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_.-", runes[i])); i++ {
+			}
+			tokens = append(tokens, filterToken{tokenIdent, string(runes[start:i]), start})
+		default:
+			symbol := string(r)
+			if i+1 < len(runes) && filterSymbols[string(runes[i:i+2])] {
+				symbol = string(runes[i : i+2])
+			}
+
+			if !filterSymbols[symbol] {
+				return nil, fmt.Errorf("unexpected '%v' at position %v", symbol, i)
+			}
+
+			start := i
+			i += len([]rune(symbol))
+
+			if symbol == "<>" {
+				symbol = "!="
+			}
+			tokens = append(tokens, filterToken{tokenSymbol, symbol, start})
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.pos >= len(p.tokens) {
+		return filterToken{kind: tokenSymbol, pos: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, fmt.Errorf("unexpected end of filter")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) expect(symbol string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.kind != tokenSymbol || t.text != symbol {
+		return fmt.Errorf("expected '%v' at position %v, got '%v'", symbol, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *filterParser) or() (filterNode, error) {
+	node, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []filterNode{node}
+	for p.peek().keyword("or") {
+		p.pos++
+		node, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return node, nil
+	}
+	return &filterOr{nodes}, nil
+}
+
+func (p *filterParser) and() (filterNode, error) {
+	node, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []filterNode{node}
+	for p.peek().keyword("and") {
+		p.pos++
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return filterAll(nodes), nil
+}
+
+func (p *filterParser) not() (filterNode, error) {
+	if p.peek().keyword("not") {
+		p.pos++
+		node, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *filterParser) primary() (filterNode, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.kind == tokenSymbol && t.text == "(" {
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+
+	if t.kind != tokenIdent {
+		return nil, fmt.Errorf("expected field at position %v, got '%v'", t.pos, t.text)
+	}
+
+	if t.keyword("exists") && p.peek().text == "(" {
+		p.pos++
+		name, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if name.kind != tokenIdent {
+			return nil, fmt.Errorf("expected field at position %v, got '%v'", name.pos, name.text)
+		}
+
+		field := newFilterField(name.text)
+		if field.column != "" {
+			return nil, fmt.Errorf("exists only applies to data fields, got %v", field)
+		}
+
+		return &filterExists{field}, p.expect(")")
+	}
+
+	return p.condition(newFilterField(t.text))
+}
+
+func (p *filterParser) condition(field filterField) (filterNode, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case t.keyword("in"):
+		return p.in(field, false)
+	case t.keyword("not"):
+		in, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !in.keyword("in") {
+			return nil, fmt.Errorf("expected 'in' at position %v, got '%v'", in.pos, in.text)
+		}
+		return p.in(field, true)
+	case t.kind == tokenSymbol && t.text != "(" && t.text != ")" && t.text != ",":
+	case t.keyword("like"), t.keyword("ilike"):
+	default:
+		return nil, fmt.Errorf("expected operator at position %v, got '%v'", t.pos, t.text)
+	}
+
+	op := strings.ToLower(t.text)
+
+	v, err := p.value(field)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &filterCompare{field: field, op: op, value: v}
+
+	switch op {
+	case "~", "!~", "like", "ilike":
+		if field.column == "created_at" {
+			return nil, fmt.Errorf("operator %v does not apply to created_at", op)
+		}
+	}
+
+	switch op {
+	case "~", "!~":
+		if node.re, err = regexp.Compile(v.text); err != nil {
+			return nil, fmt.Errorf("invalid regex for %v: %v", field, err)
+		}
+	case "like", "ilike":
+		node.re = likeRegexp(v.text, op == "ilike")
+	}
+
+	return node, nil
+}
+
+func (p *filterParser) in(field filterField, negate bool) (filterNode, error) {
+	if field.column == "created_at" {
+		return nil, fmt.Errorf("operator in does not apply to created_at")
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	node := &filterIn{field: field, negate: negate}
+	for {
+		v, err := p.value(field)
+		if err != nil {
+			return nil, err
+		}
+		node.values = append(node.values, v)
+
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tokenSymbol && t.text == ")" {
+			return node, nil
+		}
+		if t.kind != tokenSymbol || t.text != "," {
+			return nil, fmt.Errorf("expected ',' or ')' at position %v, got '%v'", t.pos, t.text)
+		}
+	}
+}
+
+func (p *filterParser) value(field filterField) (filterValue, error) {
+	t, err := p.next()
+	if err != nil {
+		return filterValue{}, err
+	}
+
+	var v filterValue
+
+	switch {
+	case t.kind == tokenString:
+		v = filterValue{text: t.text}
+	case t.kind == tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return v, fmt.Errorf("invalid number '%v' at position %v", t.text, t.pos)
+		}
+		v = filterValue{text: t.text, number: n, isNumber: true}
+	case t.keyword("true"), t.keyword("false"):
+		v = filterValue{text: strings.ToLower(t.text)}
+	default:
+		return v, fmt.Errorf("expected value at position %v, got '%v'", t.pos, t.text)
+	}
+
+	if field.column == "created_at" {
+		for _, layout := range filterTimeLayouts {
+			if ts, err := time.Parse(layout, v.text); err == nil {
+				v.time = ts
+				return v, nil
+			}
+		}
+		return v, fmt.Errorf("invalid time '%v' for created_at, use YYYY-MM-DD or RFC3339", v.text)
+	}
+
+	return v, nil
+}
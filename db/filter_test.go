@@ -0,0 +1,136 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var filterTestRecords = []Data{
+	{Id: "a", Asset: "admin.example.com", Target: "example", Data: map[string]interface{}{"port": 443.0, "title": "Admin Login", "status": "200"}},
+	{Id: "b", Asset: "www.example.com", Target: "example", Data: map[string]interface{}{"port": 80.0, "title": "Welcome", "status": "301", "waf": true}},
+	{Id: "c", Asset: "dev.other.org", Target: "other", Data: map[string]interface{}{"port": "8080", "title": "admin panel", "target": "x"}},
+	{Id: "d", Asset: "mail.other.org", Target: "other", Data: map[string]interface{}{"status": "n/a"}},
+}
+
+var filterTests = []struct {
+	where string
+	want  string
+}{
+	{"", "a,b,c,d"},
+	{"port = 443", "a"},
+	{"port > 100", "a,c"},
+	{"port >= 80 and port < 443", "b"},
+	{"status != '200'", "b,d"},
+	{"status > 250", "b"},
+	{"port in (80, 8080)", "b,c"},
+	{"port not in (80)", "a,c"},
+	{"exists(waf)", "b"},
+	{"not exists(waf)", "a,c,d"},
+	{"waf = true", "b"},
+	{"title ~ '^[Aa]dmin'", "a,c"},
+	{"title !~ 'Login'", "b,c"},
+	{"title like 'admin%'", "c"},
+	{"title ilike 'admin%'", "a,c"},
+	{"asset ilike '%.example.com'", "a,b"},
+	{"target = 'other' or port = 80", "b,c,d"},
+	{"(target = 'other' or port = 80) and not status = 'n/a'", "b,c"},
+	{"data.target = 'x'", "c"},
+	{"id in ('a', 'd')", "a,d"},
+	{"created_at > '2000-01-01'", "a,b,c,d"},
+	{"created_at < '2000-01-01T00:00:00Z'", ""},
+}
+
+func filterTestIds(rows []Data) string {
+	var ids []string
+	for _, r := range rows {
+		ids = append(ids, r.Id)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestFilter(t *testing.T) {
+	sqlite, _ := testSqlite(t)
+	memory := &MemoryService{}
+
+	for _, r := range filterTestRecords {
+		memory.Insert("services", r, false)
+		if _, err := sqlite.Save("services", "p", r.Id, r, copyData(r).Data, SaveOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range filterTests {
+		filter, err := ParseFilter(tt.where)
+		if err != nil {
+			t.Errorf("%q: %v", tt.where, err)
+			continue
+		}
+
+		for name, ds := range map[string]DataService{"sqlite": sqlite, "memory": memory} {
+			rows, err := ds.Retrieve("services", "p", filter, time.Hour)
+			if err != nil {
+				t.Errorf("%v %q: %v", name, tt.where, err)
+				continue
+			}
+
+			if got := filterTestIds(rows); got != tt.want {
+				t.Errorf("%v %q: want = [%v], got = [%v]", name, tt.where, tt.want, got)
+			}
+		}
+	}
+
+	filter, _ := ParseFilter("port >= 443 or title ~ 'panel'")
+	rows, _ := sqlite.RetrieveByTarget("services", filter, "other")
+	if got := filterTestIds(rows); got != "c" {
+		t.Errorf("want = [c] for target, got = [%v]", got)
+	}
+}
+
+func TestFilterPostgresPlaceholders(t *testing.T) {
+	placeholder := regexp.MustCompile(`\$(\d+)`)
+
+	for _, tt := range filterTests {
+		filter, _ := ParseFilter(tt.where)
+		filter = FieldsFilter(map[string]string{"scope": "true"}, map[string]string{"score": "10"}).And(filter)
+
+		sql, args := filter.compile(&filterCompiler{alias: "A.", dollar: true, offset: 3})
+
+		max := 0
+		for _, m := range placeholder.FindAllStringSubmatch(sql, -1) {
+			var n int
+			fmt.Sscan(m[1], &n)
+			if n > max {
+				max = n
+			}
+		}
+
+		if max != len(args)+3 {
+			t.Errorf("%q: want placeholders up to $%v, got $%v in %v", tt.where, len(args)+3, max, sql)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, where := range []string{
+		"port >",
+		"port 80",
+		"(port = 80",
+		"port = 80)",
+		"title ~ '('",
+		"title = 'open",
+		"exists(asset)",
+		"created_at > 'yesterday'",
+		"created_at in ('2021-01-01')",
+		"port = 80 and",
+		"port ! 80",
+	} {
+		if _, err := ParseFilter(where); err == nil {
+			t.Errorf("%q: want error", where)
+		}
+	}
+}
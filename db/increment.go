@@ -0,0 +1,67 @@
+package db
+
+import "time"
+
+// INCREMENT_LAG keeps records created just before retrieving an increment
+// for the next one, so records of transactions in flight are not missed
+var INCREMENT_LAG = 5 * time.Second
+
+// Increment holds the records of a table which were created after the
+// watermarks of a pipe. Targets without a watermark or with a refresh
+// due are loaded completely.
+type Increment struct {
+	Rows      []Data
+	Mark      string   // upper bound of created_at, formatted by the backend
+	Refreshed []string // targets which were loaded completely
+}
+
+// targets returns all targets whose watermark is moved by the increment
+func (i Increment) targets() []string {
+	seen := make(map[string]struct{})
+	var targets []string
+
+	for _, t := range i.Refreshed {
+		if _, ok := seen[t]; !ok {
+			seen[t] = struct{}{}
+			targets = append(targets, t)
+		}
+	}
+
+	for _, r := range i.Rows {
+		if _, ok := seen[r.Target]; !ok {
+			seen[r.Target] = struct{}{}
+			targets = append(targets, r.Target)
+		}
+	}
+
+	return targets
+}
+
+// Without drops the rows of targets from the increment, so their
+// watermarks are not moved when it is committed
+func (i Increment) Without(targets map[string]bool) Increment {
+	inc := Increment{Mark: i.Mark}
+
+	for _, t := range i.Refreshed {
+		if !targets[t] {
+			inc.Refreshed = append(inc.Refreshed, t)
+		}
+	}
+
+	for _, r := range i.Rows {
+		if !targets[r.Target] {
+			inc.Rows = append(inc.Rows, r)
+		}
+	}
+
+	return inc
+}
+
+func (i Increment) refreshed(target string) bool {
+	for _, t := range i.Refreshed {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func testIncrement(t *testing.T, ds DataService) {
+	t.Helper()
+
+	lag := INCREMENT_LAG
+	INCREMENT_LAG = 0
+	defer func() { INCREMENT_LAG = lag }()
+
+	save := func(id, target string) {
+		// sqlite timestamps have a precision of milliseconds
+		time.Sleep(2 * time.Millisecond)
+		if _, err := ds.Save("domains", "p", id, Data{Asset: id, Target: target}, map[string]interface{}{}, SaveOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	save("a1", "a")
+	save("a2", "a")
+	save("b1", "b")
+
+	inc, err := ds.RetrieveIncrement("domains", "scan", "", Filter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inc.Rows) != 3 || len(inc.Refreshed) != 2 {
+		t.Fatalf("want all records on first run, got %+v", inc)
+	}
+
+	if err := ds.CommitIncrement("domains", "scan", inc); err != nil {
+		t.Fatal(err)
+	}
+
+	if inc, _ := ds.RetrieveIncrement("domains", "scan", "", Filter{}, 0); len(inc.Rows) != 0 {
+		t.Errorf("want no records after commit, got %+v", inc.Rows)
+	}
+
+	save("a3", "a")
+
+	inc, _ = ds.RetrieveIncrement("domains", "scan", "", Filter{}, 0)
+	if len(inc.Rows) != 1 || inc.Rows[0].Id != "a3" || len(inc.Refreshed) != 0 {
+		t.Errorf("want only new record, got %+v", inc)
+	}
+	ds.CommitIncrement("domains", "scan", inc)
+
+	if inc, _ := ds.RetrieveIncrement("domains", "other", "", Filter{}, 0); len(inc.Rows) != 4 {
+		t.Errorf("want watermarks per pipe, got %+v", inc.Rows)
+	}
+
+	if inc, _ := ds.RetrieveIncrement("domains", "scan", "b", Filter{}, time.Millisecond); len(inc.Rows) != 1 || inc.Rows[0].Id != "b1" {
+		t.Errorf("want full refresh of target b, got %+v", inc.Rows)
+	}
+
+	if inc, _ := ds.RetrieveIncrement("domains", "scan", "", Filter{}, time.Hour); len(inc.Rows) != 0 {
+		t.Errorf("want no refresh within duration, got %+v", inc.Rows)
+	}
+}
+
+func TestSqliteIncrement(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testIncrement(t, ds)
+}
+
+func TestMemoryIncrement(t *testing.T) {
+	testIncrement(t, &MemoryService{})
+}
+
+func TestIncrementWithout(t *testing.T) {
+	inc := Increment{
+		Mark:      "mark",
+		Rows:      []Data{{Id: "a1", Target: "a"}, {Id: "b1", Target: "b"}},
+		Refreshed: []string{"a", "b"},
+	}
+
+	got := inc.Without(map[string]bool{"b": true})
+	if len(got.Rows) != 1 || got.Rows[0].Id != "a1" || len(got.Refreshed) != 1 || got.Mark != "mark" {
+		t.Errorf("want rows and refresh of a, got %+v", got)
+	}
+
+	if targets := got.targets(); len(targets) != 1 || targets[0] != "a" {
+		t.Errorf("want watermark of a to be moved, got %v", targets)
+	}
+}
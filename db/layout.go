@@ -0,0 +1,33 @@
+package db
+
+// Layout configures how records of data tables are keyed
+type Layout struct {
+	// key records of all tables by (target, id) instead of id, so
+	// records shared by multiple targets are tracked independently
+	KeyByTarget bool `yaml:"key_by_target"`
+
+	// per table settings, overriding the global ones
+	Tables map[string]TableOptions
+}
+
+type TableOptions struct {
+	KeyByTarget *bool `yaml:"key_by_target"`
+}
+
+// KeyedByTarget reports whether records of a table are keyed
+// by (target, id)
+func (l Layout) KeyedByTarget(table string) bool {
+	if o, ok := l.Tables[table]; ok && o.KeyByTarget != nil {
+		return *o.KeyByTarget
+	}
+	return l.KeyByTarget
+}
+
+// recordWhere returns the condition selecting a single record and its
+// arguments, depending on the key of the table
+func (l Layout) recordWhere(table, id, target string, placeholder func(int) string) (string, []interface{}) {
+	if l.KeyedByTarget(table) {
+		return "id = " + placeholder(1) + " AND target = " + placeholder(2), []interface{}{id, target}
+	}
+	return "id = " + placeholder(1), []interface{}{id}
+}
@@ -0,0 +1,46 @@
+package db
+
+import "time"
+
+// Source is the input of a pipe which produced a record
+type Source struct {
+	Table  string // see pipe.Source
+	Ident  string
+	TaskId string
+}
+
+// Lineage is an edge of pipers_lineage, the record (Table, Target,
+// Ident) was produced from (SourceTable, Target, SourceIdent)
+type Lineage struct {
+	Table       string    `json:"table"`
+	Target      string    `json:"target"`
+	Ident       string    `json:"ident"`
+	SourceTable string    `json:"source_table"`
+	SourceIdent string    `json:"source_ident"`
+	Pipe        string    `json:"pipe"`
+	TaskId      string    `json:"task_id"`
+	Created     time.Time `json:"created_at"`
+}
+
+// lineageWalk selects the edges of the ancestry of a record, or of its
+// descendants. The recursive part joins with the previous edges,
+// UNION stops at cycles.
+func lineageWalk(descendants bool) string {
+	start := "tbl = %[1]v AND ident = %[2]v"
+	join := "L.tbl = W.source_tbl AND L.ident = W.source_ident"
+	if descendants {
+		start = "source_tbl = %[1]v AND source_ident = %[2]v"
+		join = "L.source_tbl = W.tbl AND L.source_ident = W.ident"
+	}
+
+	return `
+		WITH RECURSIVE walk (tbl, target, ident, source_tbl, source_ident, pipe, task_id, created_at) AS (
+			SELECT tbl, target, ident, source_tbl, source_ident, pipe, task_id, created_at
+			FROM pipers_lineage WHERE ` + start + ` AND (%[3]v = '' OR target = %[3]v)
+			UNION
+			SELECT L.tbl, L.target, L.ident, L.source_tbl, L.source_ident, L.pipe, L.task_id, L.created_at
+			FROM pipers_lineage L JOIN walk W ON ` + join + ` AND L.target = W.target
+		)
+		SELECT tbl, target, ident, source_tbl, source_ident, pipe, task_id, created_at FROM walk
+		ORDER BY created_at, tbl, ident`
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testLineage(t *testing.T, ds DataService) {
+	save := func(table, id, sourceTable, sourceIdent string) {
+		data := Data{Asset: id, Target: "example"}
+		src := &Source{Table: sourceTable, Ident: sourceIdent, TaskId: "task-" + id}
+
+		if _, err := ds.Save(table, "p", id, data, map[string]interface{}{}, SaveOptions{Source: src}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save("domains", "a.example.com", "domains", "example.com")
+	save("domains", "b.a.example.com", "domains", "a.example.com")
+	save("services", "https://b.a.example.com", "domains", "b.a.example.com")
+	save("services", "https://b.a.example.com", "domains", "a.example.com")
+
+	// saving again does not add edges, a cycle does not loop
+	save("domains", "a.example.com", "domains", "example.com")
+	save("domains", "example.com", "domains", "b.a.example.com")
+
+	edges := func(e []Lineage) string {
+		var s []string
+		for _, l := range e {
+			s = append(s, l.Table+":"+l.Ident+"<"+l.SourceIdent)
+		}
+		sort.Strings(s)
+		return strings.Join(s, ",")
+	}
+
+	up, err := ds.Lineage("services", "", "https://b.a.example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(up); got != 5 {
+		t.Errorf("want = 5 ancestor edges, got = %v", edges(up))
+	}
+
+	down, err := ds.Lineage("domains", "example", "b.a.example.com", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "domains:a.example.com<example.com,domains:b.a.example.com<a.example.com,domains:example.com<b.a.example.com,services:https://b.a.example.com<a.example.com,services:https://b.a.example.com<b.a.example.com"
+	if got := edges(down); got != want {
+		t.Errorf("want = %v, got = %v", want, got)
+	}
+
+	for _, e := range down {
+		if e.TaskId != "task-"+e.Ident || e.Pipe != "p" || e.Target != "example" || e.Created.IsZero() {
+			t.Errorf("unexpected edge %+v", e)
+		}
+	}
+
+	if other, _ := ds.Lineage("domains", "other", "b.a.example.com", true); len(other) != 0 {
+		t.Errorf("want no edges for other target, got %v", edges(other))
+	}
+}
+
+func TestSqliteLineage(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testLineage(t, ds)
+}
+
+func TestMemoryLineage(t *testing.T) {
+	testLineage(t, &MemoryService{})
+}
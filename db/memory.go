@@ -0,0 +1,1004 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryService is a DataService which keeps all tables, tasks and
+// alerts in memory. It is used for local runs without a database
+// and in tests. The zero value is ready to use.
+type MemoryService struct {
+	Layout Layout
+	Scope  Scope
+
+	mu      sync.Mutex
+	tables  map[string]map[string]*memoryRecord
+	tasks   []Task
+	alerts  []Alert
+	history []History
+	lastRun map[lastRunKey]time.Time
+
+	watermarks map[watermarkKey]*memoryWatermark
+	targets    map[string]Target
+	pipes      map[string]bool      // paused pipes
+	resumed    map[string]time.Time // resume times, keyed by pipe: or target: and name
+	lineage    []Lineage
+	exclusions []memoryExclusion
+}
+
+// memoryExclusion is an exclusion cascaded from a root record
+type memoryExclusion struct {
+	Record
+	rootTable, rootIdent string
+}
+
+type watermarkKey struct {
+	pipe, table, target string
+}
+
+type memoryWatermark struct {
+	mark      time.Time
+	refreshed time.Time
+}
+
+type lastRunKey struct {
+	pipe, table, target, ident string
+}
+
+type memoryRecord struct {
+	Data
+	Exclude  bool
+	Inactive bool
+	Created  time.Time
+	LastSeen time.Time
+}
+
+func (m *MemoryService) table(name string) map[string]*memoryRecord {
+	if m.tables == nil {
+		m.tables = make(map[string]map[string]*memoryRecord)
+	}
+
+	if m.tables[name] == nil {
+		m.tables[name] = make(map[string]*memoryRecord)
+	}
+
+	return m.tables[name]
+}
+
+// recordKey returns the key of a record within its table
+func (m *MemoryService) recordKey(table, id, target string) string {
+	if m.Layout.KeyedByTarget(table) {
+		return target + "\x00" + id
+	}
+	return id
+}
+
+// Insert adds a record to a table, replacing an existing one with the
+// same key. It can be used to seed targets and exclusions, the target
+// of the record is registered.
+func (m *MemoryService) Insert(table string, data Data, exclude bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.targets[data.Target]; !ok {
+		m.addTarget(Target{Name: data.Target})
+	}
+
+	m.table(table)[m.recordKey(table, data.Id, data.Target)] = &memoryRecord{
+		Data:     copyData(data),
+		Exclude:  exclude,
+		Created:  time.Now(),
+		LastSeen: time.Now(),
+	}
+}
+
+// Tasks returns all tasks added so far
+func (m *MemoryService) Tasks() []Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Task{}, m.tasks...)
+}
+
+// Alerts returns all alerts saved so far
+func (m *MemoryService) Alerts() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Alert{}, m.alerts...)
+}
+
+// History returns all previous versions of updated records
+func (m *MemoryService) History() []History {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]History{}, m.history...)
+}
+
+// Records returns all records of a table, ordered by creation
+func (m *MemoryService) Records(table string) []Data {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.records(table, func(*memoryRecord) bool { return true })
+}
+
+func (m *MemoryService) records(table string, match func(*memoryRecord) bool) []Data {
+	result := []Data{}
+	for _, r := range m.sorted(table, match) {
+		result = append(result, copyData(r.Data))
+	}
+
+	return result
+}
+
+// sorted returns the matching records of a table ordered by creation
+func (m *MemoryService) sorted(table string, match func(*memoryRecord) bool) []*memoryRecord {
+	var records []*memoryRecord
+	for _, r := range m.table(table) {
+		if match(r) {
+			records = append(records, r)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Created.Equal(records[j].Created) {
+			return records[i].Id < records[j].Id
+		}
+		return records[i].Created.Before(records[j].Created)
+	})
+
+	return records
+}
+
+func (m *MemoryService) AddTask(t Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t.Created.IsZero() {
+		t.Created = time.Now()
+	}
+
+	if m.lastRun == nil {
+		m.lastRun = make(map[lastRunKey]time.Time)
+	}
+
+	m.lastRun[lastRunKey{t.Pipe, t.Table, t.Target, t.Ident}] = t.Created
+	m.tasks = append(m.tasks, t)
+
+	return nil
+}
+
+func (m *MemoryService) Due(pipe, table, target string, idents []string, interval time.Duration) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := time.Now().Add(-interval)
+	recent := make(map[string]struct{})
+
+	for key, run := range m.lastRun {
+		if key.pipe == pipe && key.table == table && key.target == target && run.After(since) {
+			recent[key.ident] = struct{}{}
+		}
+	}
+
+	return withoutIdents(idents, recent), nil
+}
+
+func (m *MemoryService) recentRun(pipe, table, target, ident string, since time.Time) bool {
+	run, ok := m.lastRun[lastRunKey{pipe, table, target, ident}]
+	return ok && run.After(since)
+}
+
+func (m *MemoryService) Retrieve(table, pipeName string, filter Filter, interval time.Duration) ([]Data, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := time.Now().Add(-interval)
+
+	return m.records(table, func(r *memoryRecord) bool {
+		if r.Exclude || r.Inactive || !filter.match(filterRecord{r.Data, r.Created}) {
+			return false
+		}
+
+		return !m.recentRun(pipeName, table, r.Target, r.Id, since)
+	}), nil
+}
+
+func (m *MemoryService) RetrieveByTarget(table string, filter Filter, target string) ([]Data, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.records(table, func(r *memoryRecord) bool {
+		return !r.Exclude && !r.Inactive && r.Target == target && filter.match(filterRecord{r.Data, r.Created})
+	}), nil
+}
+
+func (m *MemoryService) RetrieveIncrement(table, pipeName, target string, filter Filter, refresh time.Duration) (Increment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mark := time.Now().Add(-INCREMENT_LAG)
+	inc := Increment{Mark: mark.Format(time.RFC3339Nano)}
+
+	full := make(map[string]bool)
+	for _, r := range m.table(table) {
+		if (target != "" && r.Target != target) || r.Exclude || r.Inactive || r.Created.After(mark) {
+			continue
+		}
+
+		if _, ok := full[r.Target]; ok {
+			continue
+		}
+
+		w, ok := m.watermarks[watermarkKey{pipeName, table, r.Target}]
+		full[r.Target] = !ok || (refresh > 0 && w.refreshed.Before(time.Now().Add(-refresh)))
+		if full[r.Target] {
+			inc.Refreshed = append(inc.Refreshed, r.Target)
+		}
+	}
+	sort.Strings(inc.Refreshed)
+
+	inc.Rows = m.records(table, func(r *memoryRecord) bool {
+		if (target != "" && r.Target != target) || r.Exclude || r.Inactive || r.Created.After(mark) {
+			return false
+		}
+
+		if !full[r.Target] && !r.Created.After(m.watermarks[watermarkKey{pipeName, table, r.Target}].mark) {
+			return false
+		}
+
+		return filter.match(filterRecord{r.Data, r.Created})
+	})
+
+	return inc, nil
+}
+
+func (m *MemoryService) CommitIncrement(table, pipeName string, inc Increment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mark, err := time.Parse(time.RFC3339Nano, inc.Mark)
+	if err != nil {
+		return err
+	}
+
+	if m.watermarks == nil {
+		m.watermarks = make(map[watermarkKey]*memoryWatermark)
+	}
+
+	for _, target := range inc.targets() {
+		key := watermarkKey{pipeName, table, target}
+		if m.watermarks[key] == nil {
+			m.watermarks[key] = &memoryWatermark{}
+		}
+
+		m.watermarks[key].mark = mark
+		if inc.refreshed(target) {
+			m.watermarks[key].refreshed = time.Now()
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryService) AddTarget(t Target) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addTarget(t)
+
+	return nil
+}
+
+func (m *MemoryService) addTarget(t Target) {
+	if m.targets == nil {
+		m.targets = make(map[string]Target)
+	}
+
+	if stored, ok := m.targets[t.Name]; ok {
+		t.Created = stored.Created
+		t.Paused = stored.Paused
+	} else {
+		t.Created = time.Now()
+	}
+
+	m.targets[t.Name] = t
+}
+
+func (m *MemoryService) RetrieveTargets() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	targets := []string{}
+	for name := range m.targets {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+
+	return targets, nil
+}
+
+func (m *MemoryService) ListTargets() ([]Target, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var targets []Target
+	for _, t := range m.targets {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+
+	return targets, nil
+}
+
+func (m *MemoryService) PauseTarget(name string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.targets[name]
+	if !ok {
+		return fmt.Errorf("target %v not found", name)
+	}
+
+	if t.Paused && !paused {
+		m.resume("target:" + name)
+	}
+
+	t.Paused = paused
+	m.targets[name] = t
+
+	return nil
+}
+
+func (m *MemoryService) PausePipe(name string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pipes == nil {
+		m.pipes = make(map[string]bool)
+	}
+
+	if paused {
+		m.pipes[name] = true
+	} else if m.pipes[name] {
+		delete(m.pipes, name)
+		m.resume("pipe:" + name)
+	}
+
+	return nil
+}
+
+// resume records the resume time of a pipe or target
+func (m *MemoryService) resume(key string) {
+	if m.resumed == nil {
+		m.resumed = make(map[string]time.Time)
+	}
+	m.resumed[key] = time.Now()
+}
+
+func (m *MemoryService) RetrievePaused() (Paused, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paused := newPaused()
+	for name := range m.pipes {
+		paused.Pipes[name] = true
+	}
+	for name, t := range m.targets {
+		if t.Paused {
+			paused.Targets[name] = true
+		}
+	}
+
+	return paused, nil
+}
+
+// RemoveTarget works like PostgresService.RemoveTarget, records of all
+// tables are deleted
+func (m *MemoryService) RemoveTarget(name string, tables []string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.targets[name]; !ok {
+		return nil, fmt.Errorf("target %v not found", name)
+	}
+	delete(m.targets, name)
+
+	deleted := make(map[string]int64)
+
+	for table, records := range m.tables {
+		for key, r := range records {
+			if r.Target == name {
+				delete(records, key)
+				deleted[table]++
+			}
+		}
+	}
+
+	var tasks []Task
+	for _, t := range m.tasks {
+		if t.Target == name {
+			deleted["pipers_tasks"]++
+		} else {
+			tasks = append(tasks, t)
+		}
+	}
+	m.tasks = tasks
+
+	var alerts []Alert
+	for _, a := range m.alerts {
+		if a.Target == name {
+			deleted["pipers_alerts"]++
+		} else {
+			alerts = append(alerts, a)
+		}
+	}
+	m.alerts = alerts
+
+	var history []History
+	for _, h := range m.history {
+		if h.Target == name {
+			deleted["pipers_history"]++
+		} else {
+			history = append(history, h)
+		}
+	}
+	m.history = history
+
+	for key := range m.lastRun {
+		if key.target == name {
+			delete(m.lastRun, key)
+			deleted["pipers_last_run"]++
+		}
+	}
+
+	for key := range m.watermarks {
+		if key.target == name {
+			delete(m.watermarks, key)
+			deleted["pipers_watermarks"]++
+		}
+	}
+
+	var lineage []Lineage
+	for _, l := range m.lineage {
+		if l.Target == name {
+			deleted["pipers_lineage"]++
+		} else {
+			lineage = append(lineage, l)
+		}
+	}
+	m.lineage = lineage
+
+	var exclusions []memoryExclusion
+	for _, e := range m.exclusions {
+		if e.Target == name {
+			deleted["pipers_exclusions"]++
+		} else {
+			exclusions = append(exclusions, e)
+		}
+	}
+	m.exclusions = exclusions
+
+	return deleted, nil
+}
+
+func (m *MemoryService) CountRecords(table string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int64)
+	for _, r := range m.table(table) {
+		counts[r.Target]++
+	}
+
+	return counts, nil
+}
+
+func (m *MemoryService) RetrieveBlocked(target string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]struct{})
+	blocked := []string{}
+
+	for _, table := range m.Scope.exclusionTables() {
+		byTarget := m.Layout.KeyedByTarget(table)
+
+		for _, r := range m.table(table) {
+			if _, ok := seen[r.Asset]; ok || !r.Exclude || (byTarget && r.Target != target) {
+				continue
+			}
+			seen[r.Asset] = struct{}{}
+			blocked = append(blocked, r.Asset)
+		}
+	}
+	sort.Strings(blocked)
+
+	return blocked, nil
+}
+
+func (m *MemoryService) Save(table, pipe, id string, data Data, result map[string]interface{}, opts SaveOptions) (SaveResult, error) {
+	var res SaveResult
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// if a asset is provided, use the provided one if valid
+	asset := data.Asset
+	if v, ok := result["asset"].(string); ok && v != "" {
+		asset = v
+	}
+
+	delete(result, "asset")
+
+	if s := opts.Source; s != nil {
+		m.addLineage(Lineage{
+			Table:       table,
+			Target:      data.Target,
+			Ident:       id,
+			SourceTable: s.Table,
+			SourceIdent: s.Ident,
+			Pipe:        pipe,
+			TaskId:      s.TaskId,
+			Created:     time.Now(),
+		})
+	}
+
+	records := m.table(table)
+	key := m.recordKey(table, id, data.Target)
+	if stored, ok := records[key]; ok {
+		res.Reactivated = stored.Inactive
+		res.Changes = diffFields(stored.Data.Data, result, opts.Track)
+
+		// the record was seen again
+		stored.Inactive = false
+		stored.LastSeen = time.Now()
+		if opts.AssetType != "" {
+			stored.AssetType = opts.AssetType
+		}
+
+		if len(res.Changes) == 0 {
+			return res, nil
+		}
+
+		m.history = append(m.history, History{
+			Table:   table,
+			Target:  data.Target,
+			Ident:   id,
+			Pipe:    pipe,
+			Data:    copyData(stored.Data).Data,
+			Created: time.Now(),
+		})
+
+		stored.Data.Data = copyData(Data{Data: result}).Data
+		res.Updated = true
+
+		return res, nil
+	}
+
+	if _, ok := m.targets[data.Target]; !ok {
+		m.addTarget(Target{Name: data.Target})
+	}
+
+	records[key] = &memoryRecord{
+		Data: copyData(Data{
+			Id:        id,
+			Asset:     asset,
+			AssetType: opts.AssetType,
+			Target:    data.Target,
+			Pipe:      pipe,
+			Data:      result,
+		}),
+		Created:  time.Now(),
+		LastSeen: time.Now(),
+	}
+	res.Inserted = true
+
+	return res, nil
+}
+
+// addLineage adds an edge unless it exists
+func (m *MemoryService) addLineage(l Lineage) {
+	for _, e := range m.lineage {
+		if e.Table == l.Table && e.Target == l.Target && e.Ident == l.Ident && e.SourceTable == l.SourceTable && e.SourceIdent == l.SourceIdent {
+			return
+		}
+	}
+	m.lineage = append(m.lineage, l)
+}
+
+func (m *MemoryService) Lineage(table, target, ident string, descendants bool) ([]Lineage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type node struct{ table, target, ident string }
+
+	// edges link a record to its parents, or to its children
+	linked := func(e Lineage, n node) bool {
+		if n.target != "" && e.Target != n.target {
+			return false
+		}
+		if descendants {
+			return e.SourceTable == n.table && e.SourceIdent == n.ident
+		}
+		return e.Table == n.table && e.Ident == n.ident
+	}
+
+	var edges []Lineage
+	seen := make(map[int]bool)
+	queue := []node{{table, target, ident}}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		for i, e := range m.lineage {
+			if seen[i] || !linked(e, n) {
+				continue
+			}
+			seen[i] = true
+			edges = append(edges, e)
+
+			if descendants {
+				queue = append(queue, node{e.Table, e.Target, e.Ident})
+			} else {
+				queue = append(queue, node{e.SourceTable, e.Target, e.SourceIdent})
+			}
+		}
+	}
+
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].Created.Before(edges[j].Created)
+	})
+
+	return edges, nil
+}
+
+func (m *MemoryService) Exclude(table, target, ident string, opts ExcludeOptions) ([]Record, error) {
+	edges, err := m.Lineage(table, target, ident, true)
+	if err != nil {
+		return nil, err
+	}
+
+	covered, err := m.coveredEdges(table, target, ident)
+	if err != nil {
+		return nil, err
+	}
+	edges = append(edges, covered...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.setRootExclude(table, target, ident, true) {
+		return nil, fmt.Errorf("record %v not found in %v", ident, table)
+	}
+
+	var affected []Record
+	for _, r := range descendants(edges, Record{Table: table, Target: target, Ident: ident}) {
+		records := m.table(r.Table)
+		key := m.recordKey(r.Table, r.Ident, r.Target)
+
+		stored, ok := records[key]
+		if !ok {
+			continue
+		}
+
+		if opts.Delete {
+			delete(records, key)
+			m.removeExclusions(r)
+			affected = append(affected, r)
+			continue
+		}
+
+		// excluded independently of a cascade
+		if stored.Exclude && !m.cascaded(r) {
+			continue
+		}
+
+		m.addExclusion(memoryExclusion{r, table, ident})
+
+		if !stored.Exclude {
+			stored.Exclude = true
+			affected = append(affected, r)
+		}
+	}
+
+	return affected, nil
+}
+
+// coveredEdges works like PostgresService.coveredEdges
+func (m *MemoryService) coveredEdges(table, target, ident string) ([]Lineage, error) {
+	m.mu.Lock()
+	roots := m.records(table, func(r *memoryRecord) bool {
+		return r.Id == ident && (target == "" || r.Target == target)
+	})
+	m.mu.Unlock()
+
+	asset := func(r Record) string {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if stored, ok := m.table(r.Table)[m.recordKey(r.Table, r.Ident, r.Target)]; ok {
+			return stored.Asset
+		}
+		return ""
+	}
+
+	var edges []Lineage
+	for _, root := range roots {
+		covered, err := coveredEdges(m, Record{Table: table, Target: root.Target, Ident: ident}, root.Asset, asset)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, covered...)
+	}
+
+	return edges, nil
+}
+
+func (m *MemoryService) Include(table, target, ident string) ([]Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.setRootExclude(table, target, ident, false) {
+		return nil, fmt.Errorf("record %v not found in %v", ident, table)
+	}
+
+	var cascaded []Record
+	var exclusions []memoryExclusion
+	for _, e := range m.exclusions {
+		if e.rootTable == table && e.rootIdent == ident && (target == "" || e.Target == target) {
+			cascaded = append(cascaded, e.Record)
+		} else {
+			exclusions = append(exclusions, e)
+		}
+	}
+	m.exclusions = exclusions
+
+	var affected []Record
+	for _, r := range cascaded {
+		if m.cascaded(r) {
+			continue
+		}
+
+		if stored, ok := m.table(r.Table)[m.recordKey(r.Table, r.Ident, r.Target)]; ok {
+			stored.Exclude = false
+			affected = append(affected, r)
+		}
+	}
+
+	return affected, nil
+}
+
+// setRootExclude sets the exclude flag of the record an exclusion
+// starts at and reports whether it was found
+func (m *MemoryService) setRootExclude(table, target, ident string, exclude bool) bool {
+	found := false
+	for _, r := range m.table(table) {
+		if r.Id == ident && (target == "" || r.Target == target) {
+			r.Exclude = exclude
+			found = true
+		}
+	}
+	return found
+}
+
+// cascaded reports whether the exclusion of any record cascaded to r
+func (m *MemoryService) cascaded(r Record) bool {
+	for _, e := range m.exclusions {
+		if e.Record == r {
+			return true
+		}
+	}
+	return false
+}
+
+// removeExclusions drops all exclusions cascaded to r
+func (m *MemoryService) removeExclusions(r Record) {
+	var exclusions []memoryExclusion
+	for _, e := range m.exclusions {
+		if e.Record != r {
+			exclusions = append(exclusions, e)
+		}
+	}
+	m.exclusions = exclusions
+}
+
+// addExclusion adds a cascaded exclusion unless it exists
+func (m *MemoryService) addExclusion(e memoryExclusion) {
+	for _, x := range m.exclusions {
+		if x == e {
+			return
+		}
+	}
+	m.exclusions = append(m.exclusions, e)
+}
+
+func (m *MemoryService) Export(table string, q ExportQuery, fn func(Row) error) error {
+	// validates the table and filter like the database backends
+	if _, _, err := exportQuery(table, q, &filterCompiler{}); err != nil {
+		return err
+	}
+
+	created := func(target, pipe string, t time.Time) bool {
+		return (q.Target == "" || target == q.Target) &&
+			(q.Pipe == "" || pipe == q.Pipe) &&
+			(q.Since.IsZero() || !t.Before(q.Since)) &&
+			(q.Until.IsZero() || t.Before(q.Until))
+	}
+
+	var rows []Row
+
+	m.mu.Lock()
+	switch table {
+	case "pipers_alerts":
+		for _, a := range m.alerts {
+			if created(a.Target, a.Pipe, a.Created) {
+				rows = append(rows, alertRow(a))
+			}
+		}
+	case "pipers_tasks":
+		for _, t := range m.tasks {
+			if created(t.Target, t.Pipe, t.Created) {
+				rows = append(rows, taskRow(t))
+			}
+		}
+	default:
+		records := m.sorted(table, func(r *memoryRecord) bool {
+			if q.Active && (r.Exclude || r.Inactive) {
+				return false
+			}
+			return created(r.Target, r.Pipe, r.Created) && q.Filter.match(filterRecord{r.Data, r.Created})
+		})
+
+		for _, r := range records {
+			lastSeen := r.LastSeen
+			rows = append(rows, recordRow(copyData(r.Data), r.Exclude, !r.Inactive, r.Created, &lastSeen))
+		}
+	}
+	m.mu.Unlock()
+
+	for _, row := range rows {
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryService) MarkRemoved(table, pipe string, after time.Duration) ([]Data, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := time.Now().Add(-after)
+
+	// records are kept while paused and within after of a resume
+	if m.pipes[pipe] || m.resumed["pipe:"+pipe].After(before) {
+		return nil, nil
+	}
+
+	removed := m.records(table, func(r *memoryRecord) bool {
+		return r.Pipe == pipe && !r.Inactive && r.LastSeen.Before(before) &&
+			!m.targets[r.Target].Paused && !m.resumed["target:"+r.Target].After(before)
+	})
+
+	for _, r := range removed {
+		m.table(table)[m.recordKey(table, r.Id, r.Target)].Inactive = true
+	}
+
+	return removed, nil
+}
+
+func (m *MemoryService) SaveAlert(pipe, target, id, msg, alertType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.alerts = append(m.alerts, Alert{
+		Type:    alertType,
+		Pipe:    pipe,
+		Target:  target,
+		Ident:   id,
+		Message: msg,
+		Created: time.Now(),
+	})
+
+	return nil
+}
+
+func (m *MemoryService) Prune(table, target string, maxAge time.Duration, dryRun bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := time.Now().Add(-maxAge)
+	var n int64
+
+	switch table {
+	case "pipers_tasks":
+		var tasks []Task
+		for _, t := range m.tasks {
+			if t.Created.Before(before) {
+				n++
+			} else {
+				tasks = append(tasks, t)
+			}
+		}
+		if !dryRun {
+			m.tasks = tasks
+		}
+	case "pipers_alerts":
+		var alerts []Alert
+		for _, a := range m.alerts {
+			if a.Created.Before(before) {
+				n++
+			} else {
+				alerts = append(alerts, a)
+			}
+		}
+		if !dryRun {
+			m.alerts = alerts
+		}
+	case "pipers_history":
+		var history []History
+		for _, h := range m.history {
+			if h.Created.Before(before) {
+				n++
+			} else {
+				history = append(history, h)
+			}
+		}
+		if !dryRun {
+			m.history = history
+		}
+	default:
+		records := m.table(table)
+		for id, r := range records {
+			if (target == "" || r.Target == target) && r.LastSeen.Before(before) {
+				n++
+				if !dryRun {
+					delete(records, id)
+				}
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// jsonText renders a JSON value as text, like postgres' ->> operator
+func jsonText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// copyData returns a copy of the record, so callers can not modify
+// the stored data map (MapInput adds asset and target to it)
+func copyData(data Data) Data {
+	m := make(map[string]interface{}, len(data.Data))
+	for k, v := range data.Data {
+		m[k] = v
+	}
+	data.Data = m
+
+	return data
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryRetrieve(t *testing.T) {
+	ds := &MemoryService{}
+
+	ds.Insert("domains", Data{Id: "a", Asset: "a", Target: "rv", Data: map[string]interface{}{"score": 100.0, "scope": true}}, false)
+	ds.Insert("domains", Data{Id: "b", Asset: "b", Target: "rv", Data: map[string]interface{}{"score": 200.0}}, false)
+	ds.Insert("domains", Data{Id: "c", Asset: "c", Target: "rv"}, true)
+
+	t.Run("retrieves assets which are not excluded", func(t *testing.T) {
+		rows, _ := ds.Retrieve("domains", "http_detect", Filter{}, time.Hour)
+		if got := len(rows); got != 2 {
+			t.Errorf("want = 2, got = %v", got)
+		}
+	})
+
+	t.Run("retrieves asset with filter", func(t *testing.T) {
+		rows, _ := ds.Retrieve("domains", "http_detect", FieldsFilter(map[string]string{"scope": "true"}, nil), time.Hour)
+		if got := len(rows); got != 1 {
+			t.Errorf("want = 1, got = %v", got)
+		}
+	})
+
+	t.Run("retrieves asset with threshold", func(t *testing.T) {
+		rows, _ := ds.Retrieve("domains", "http_detect", FieldsFilter(nil, map[string]string{"score": "101"}), time.Hour)
+		if got := len(rows); got != 1 || rows[0].Id != "b" {
+			t.Errorf("want = [b], got = %v", rows)
+		}
+	})
+
+	t.Run("should not retrieve asset with task in the past", func(t *testing.T) {
+		ds.AddTask(Task{Pipe: "http_detect", Table: "domains", Target: "rv", Ident: "a"})
+
+		rows, _ := ds.Retrieve("domains", "http_detect", Filter{}, time.Hour)
+		if got := len(rows); got != 1 {
+			t.Errorf("want = 1, got = %v", got)
+		}
+
+		if due, _ := ds.Due("http_detect", "domains", "rv", []string{"a", "b"}, time.Hour); len(due) != 1 || due[0] != "b" {
+			t.Errorf("want = [b] to be due, got %v", due)
+		}
+
+		if due, _ := ds.Due("http_foobar", "domains", "rv", []string{"a"}, time.Hour); len(due) != 1 {
+			t.Errorf("want a to be due for different pipe, got %v", due)
+		}
+
+		if due, _ := ds.Due("http_detect", "domains", "other", []string{"a"}, time.Hour); len(due) != 1 {
+			t.Errorf("want a to be due for different target, got %v", due)
+		}
+	})
+
+	t.Run("returns targets and blocked assets", func(t *testing.T) {
+		targets, _ := ds.RetrieveTargets()
+		if len(targets) != 1 || targets[0] != "rv" {
+			t.Errorf("want = [rv], got = %v", targets)
+		}
+
+		blocked, _ := ds.RetrieveBlocked("")
+		if len(blocked) != 1 || blocked[0] != "c" {
+			t.Errorf("want = [c], got = %v", blocked)
+		}
+	})
+}
+
+func TestMemorySave(t *testing.T) {
+	ds := &MemoryService{}
+	data := Data{Asset: "example.com", Target: "example"}
+
+	res, _ := ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{"asset": "http://example.com"}, SaveOptions{})
+	if !res.Inserted {
+		t.Errorf("want first save to insert")
+	}
+
+	res, _ = ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{}, SaveOptions{})
+	if res.Inserted {
+		t.Errorf("want second save to be ignored")
+	}
+
+	records := ds.Records("services")
+	if len(records) != 1 || records[0].Asset != "http://example.com" || records[0].Pipe != "http_detect" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+
+	// modifying a returned record must not change the stored one
+	records[0].Data["foo"] = "bar"
+	if _, ok := ds.Records("services")[0].Data["foo"]; ok {
+		t.Errorf("stored record was modified")
+	}
+}
+
+func TestMemoryKeyByTarget(t *testing.T) {
+	ds := &MemoryService{Layout: Layout{KeyByTarget: true}}
+
+	ds.Insert("domains", Data{Id: "cdn.example.com", Asset: "cdn.example.com", Target: "a"}, true)
+
+	res, _ := ds.Save("domains", "p", "cdn.example.com", Data{Target: "b"}, map[string]interface{}{}, SaveOptions{})
+	if !res.Inserted {
+		t.Errorf("want shared asset to be inserted for second target")
+	}
+
+	if got := len(ds.Records("domains")); got != 2 {
+		t.Errorf("want = 2, got = %v", got)
+	}
+
+	if blocked, _ := ds.RetrieveBlocked("b"); len(blocked) != 0 {
+		t.Errorf("want asset not to be blocked for b, got %v", blocked)
+	}
+
+	ds.AddTask(Task{Pipe: "p", Table: "domains", Target: "b", Ident: "cdn.example.com"})
+	ds.Insert("domains", Data{Id: "cdn.example.com", Asset: "cdn.example.com", Target: "a"}, false)
+
+	rows, _ := ds.Retrieve("domains", "p", Filter{}, time.Hour)
+	if len(rows) != 1 || rows[0].Target != "a" {
+		t.Errorf("want record of target a to be due, got %+v", rows)
+	}
+}
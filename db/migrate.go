@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SCOPE_ESSENTIALS is the scope of MIGRATIONS in pipers_schema_version,
+// data tables use their own name as scope
+const SCOPE_ESSENTIALS = "pipers"
+
+// Migrator is implemented by all backends with a versioned schema
+type Migrator interface {
+	Migrate(tables []string) error
+	MigrationStatus(tables []string) ([]MigrationStatus, error)
+}
+
+// MigrationStatus is the schema version of the essential tables
+// or of a single data table
+type MigrationStatus struct {
+	Scope   string
+	Version int
+	Latest  int
+	Pending []Migration
+}
+
+func migrationsFor(scope string) []Migration {
+	if scope == SCOPE_ESSENTIALS {
+		return MIGRATIONS
+	}
+	return TABLE_MIGRATIONS
+}
+
+// migrationStatus returns the pending migrations of a scope
+// based on the currently applied version
+func migrationStatus(scope string, version int) MigrationStatus {
+	status := MigrationStatus{Scope: scope, Version: version}
+
+	for _, m := range migrationsFor(scope) {
+		if m.Version > status.Latest {
+			status.Latest = m.Version
+		}
+
+		if m.Version > version {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+
+	return status
+}
+
+func migrationScopes(tables []string) []string {
+	return append([]string{SCOPE_ESSENTIALS}, tables...)
+}
+
+// migrationSQL returns the statements of a migration for a scope
+func migrationSQL(scope, sql string) string {
+	if scope == SCOPE_ESSENTIALS {
+		return sql
+	}
+	return fmt.Sprintf(sql, scope)
+}
+
+// splitStatements splits a migration into single statements, migrations
+// must not contain semicolons in literals
+func splitStatements(sql string) []string {
+	var statements []string
+	for _, s := range strings.Split(sql, ";") {
+		if s = strings.TrimSpace(s); s != "" {
+			statements = append(statements, s)
+		}
+	}
+	return statements
+}
@@ -0,0 +1,27 @@
+package db
+
+// Paused holds the names of paused pipes and targets. Their tasks are
+// neither enqueued by the scheduler nor processed by workers.
+type Paused struct {
+	Pipes   map[string]bool
+	Targets map[string]bool
+}
+
+// Reason returns why a task of a pipe and target is paused, or an empty
+// string if neither is paused
+func (p Paused) Reason(pipe, target string) string {
+	switch {
+	case p.Pipes[pipe]:
+		return "pipe paused"
+	case p.Targets[target]:
+		return "target paused"
+	}
+	return ""
+}
+
+func newPaused() Paused {
+	return Paused{
+		Pipes:   make(map[string]bool),
+		Targets: make(map[string]bool),
+	}
+}
@@ -2,40 +2,30 @@ package db
 
 import (
 	"fmt"
-	"time"
-
-	"github.com/jackc/pgx/v4"
 )
 
-type PrintService struct{}
-
-func (p *PrintService) AddTask(t Task) error { return nil }
-
-func (p *PrintService) ShouldRun(pipe, ident string, interval time.Duration) bool {
-	return true
-}
-
-func (p *PrintService) Retrieve(table, pipeName string, fields map[string]string, threshold map[string]string, interval time.Duration) (pgx.Rows, error) {
-	return nil, nil
+// PrintService keeps all data in memory like MemoryService and prints
+// every record which would be new
+type PrintService struct {
+	MemoryService
 }
 
-func (p *PrintService) RetrieveTargets() ([]string, error) {
-	return []string{}, nil
-}
+func (p *PrintService) Save(table, pipe, id string, data Data, result map[string]interface{}, opts SaveOptions) (SaveResult, error) {
+	asset := data.Asset
+	if v, ok := result["asset"].(string); ok && v != "" {
+		asset = v
+	}
 
-func (p *PrintService) RetrieveBlocked() ([]string, error) {
-	return []string{}, nil
-}
+	res, err := p.MemoryService.Save(table, pipe, id, data, result, opts)
+	if err != nil {
+		return res, err
+	}
 
-func (p *PrintService) RetrieveByTarget(table string, fields map[string]string, target string) (pgx.Rows, error) {
-	return nil, nil
-}
-
-func (p *PrintService) Save(table, pipe, id string, data Data, result map[string]interface{}) (bool, error) {
-	fmt.Printf("table=%v, ident=%v, pipe=%v\ndata=%+v\nresult=%+v\n", table, id, pipe, data, result)
-	return true, nil
-}
+	if res.Inserted {
+		fmt.Printf("table=%v, ident=%v, pipe=%v, asset=%v\ninput=%+v\nresult=%+v\n", table, id, pipe, asset, data, result)
+	} else if res.Updated {
+		fmt.Printf("table=%v, ident=%v, pipe=%v, asset=%v\nchanges=%v\n", table, id, pipe, asset, res.Changes)
+	}
 
-func (p *PrintService) SaveAlert(pipe string, id, msg, alertType string) error {
-	return nil
+	return res, nil
 }
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+const PRUNE_INTERVAL_DEFAULT = "1h"
+
+// RetentionPolicy defines how long tasks, alerts, history entries and
+// records of data tables are kept. Empty values keep rows forever.
+type RetentionPolicy struct {
+	Tasks    string // time.Duration format
+	Alerts   string // time.Duration format
+	History  string // time.Duration format
+	Data     []DataRetention
+	Interval string // how often the scheduler prunes
+}
+
+// DataRetention deletes records of a table which were not seen for
+// longer than MaxAge, optionally only for a single target
+type DataRetention struct {
+	Table  string
+	Target string
+	MaxAge string `yaml:"max_age"` // time.Duration format
+}
+
+// PruneResult is the number of (prunable) rows of a table
+type PruneResult struct {
+	Table   string
+	Target  string
+	MaxAge  time.Duration
+	Deleted int64
+}
+
+type pruneRule struct {
+	table, target, maxAge string
+}
+
+func (r RetentionPolicy) rules() []pruneRule {
+	rules := []pruneRule{
+		{"pipers_tasks", "", r.Tasks},
+		{"pipers_alerts", "", r.Alerts},
+		{"pipers_history", "", r.History},
+	}
+
+	for _, d := range r.Data {
+		rules = append(rules, pruneRule{d.Table, d.Target, d.MaxAge})
+	}
+
+	return rules
+}
+
+// Enabled reports whether at least one retention rule is set
+func (r RetentionPolicy) Enabled() bool {
+	for _, rule := range r.rules() {
+		if rule.maxAge != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r RetentionPolicy) PruneInterval() (time.Duration, error) {
+	if r.Interval == "" {
+		r.Interval = PRUNE_INTERVAL_DEFAULT
+	}
+	return time.ParseDuration(r.Interval)
+}
+
+func (r RetentionPolicy) Validate() error {
+	if _, err := r.PruneInterval(); err != nil {
+		return fmt.Errorf("invalid retention interval: %w", err)
+	}
+
+	for _, rule := range r.rules() {
+		if rule.table == "" {
+			return fmt.Errorf("retention rule without table")
+		}
+
+		if rule.maxAge == "" {
+			continue
+		}
+
+		if _, err := time.ParseDuration(rule.maxAge); err != nil {
+			return fmt.Errorf("invalid retention for %v: %w", rule.table, err)
+		}
+	}
+
+	return nil
+}
+
+// Prune deletes all rows which exceed the retention policy. If dryRun is
+// set, rows are only counted.
+func Prune(ds AdminService, policy RetentionPolicy, dryRun bool) ([]PruneResult, error) {
+	var results []PruneResult
+
+	for _, rule := range policy.rules() {
+		if rule.maxAge == "" {
+			continue
+		}
+
+		maxAge, err := time.ParseDuration(rule.maxAge)
+		if err != nil {
+			return results, fmt.Errorf("invalid retention for %v: %w", rule.table, err)
+		}
+
+		n, err := ds.Prune(rule.table, rule.target, maxAge, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("pruning %v failed: %v", rule.table, err)
+		}
+
+		results = append(results, PruneResult{
+			Table:   rule.table,
+			Target:  rule.target,
+			MaxAge:  maxAge,
+			Deleted: n,
+		})
+	}
+
+	return results, nil
+}
+
+// isEssential reports whether a table is one of the pipers_* tables
+// which only have a created_at column
+func isEssential(table string) bool {
+	switch table {
+	case "pipers_tasks", "pipers_alerts", "pipers_history":
+		return true
+	}
+	return false
+}
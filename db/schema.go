@@ -1,20 +1,21 @@
 package db
 
-const SQL_CREATE_DATA_TBL = `
-CREATE TABLE IF NOT EXISTS %v (
-	id text primary key,
-	asset text not null,
-	target text not null,
-	pipe text not null,
-	exclude boolean default false,
-	data jsonb,
-	created_at TIMESTAMP DEFAULT NOW()
-);
-CREATE INDEX IF NOT EXISTS %v_asset_idx ON %v (asset);
-CREATE INDEX IF NOT EXISTS %v_target_idx ON %v (target);
-`
+// Migration is a numbered schema change. Migrations are applied in order
+// and recorded in pipers_schema_version. Statements should be idempotent,
+// so databases created before versioning can be migrated.
+type Migration struct {
+	Version  int
+	Name     string
+	Postgres string
+	SQLite   string
+}
 
-const SQL_CREATE_ESSENTIALS = `
+// MIGRATIONS create and change the essential pipers_* tables
+var MIGRATIONS = []Migration{
+	{
+		Version: 1,
+		Name:    "create tasks and alerts",
+		Postgres: `
 CREATE TABLE IF NOT EXISTS pipers_tasks (
 	id serial primary key,
 	pipe text not null,
@@ -35,4 +36,460 @@ CREATE TABLE IF NOT EXISTS pipers_alerts (
 );
 CREATE INDEX IF NOT EXISTS alerts_pipe_idx ON pipers_alerts (pipe);
 CREATE INDEX IF NOT EXISTS alerts_ident_idx ON pipers_alerts (ident);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS pipers_tasks (
+	id integer primary key autoincrement,
+	pipe text not null,
+	ident text not null,
+	note text,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS tasks_pipe_idx ON pipers_tasks (pipe);
+CREATE INDEX IF NOT EXISTS tasks_ident_idx ON pipers_tasks (ident);
+
+CREATE TABLE IF NOT EXISTS pipers_alerts (
+	id integer primary key autoincrement,
+	type text not null,
+	pipe text not null,
+	ident text not null,
+	message text,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS alerts_pipe_idx ON pipers_alerts (pipe);
+CREATE INDEX IF NOT EXISTS alerts_ident_idx ON pipers_alerts (ident);
+`,
+	},
+	{
+		Version: 2,
+		Name:    "create history",
+		Postgres: `
+CREATE TABLE IF NOT EXISTS pipers_history (
+	id serial primary key,
+	tbl text not null,
+	ident text not null,
+	pipe text not null,
+	data jsonb,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS history_ident_idx ON pipers_history (tbl, ident);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS pipers_history (
+	id integer primary key autoincrement,
+	tbl text not null,
+	ident text not null,
+	pipe text not null,
+	data text,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS history_ident_idx ON pipers_history (tbl, ident);
+`,
+	},
+	{
+		Version: 3,
+		Name:    "create last run",
+		Postgres: `
+CREATE TABLE IF NOT EXISTS pipers_last_run (
+	pipe text not null,
+	tbl text not null,
+	ident text not null,
+	run_at TIMESTAMP DEFAULT NOW(),
+	primary key (pipe, tbl, ident)
+);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS pipers_last_run (
+	pipe text not null,
+	tbl text not null,
+	ident text not null,
+	run_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	primary key (pipe, tbl, ident)
+);
+`,
+	},
+	{
+		Version: 4,
+		Name:    "add tbl to tasks",
+		Postgres: `
+ALTER TABLE pipers_tasks ADD COLUMN IF NOT EXISTS tbl text not null default '';
+CREATE INDEX IF NOT EXISTS tasks_tbl_ident_idx ON pipers_tasks (pipe, tbl, ident);
+`,
+		SQLite: `
+ALTER TABLE pipers_tasks ADD COLUMN tbl text not null default '';
+CREATE INDEX IF NOT EXISTS tasks_tbl_ident_idx ON pipers_tasks (pipe, tbl, ident);
+`,
+	},
+	{
+		// records keyed by (target, id) are run and versioned per target
+		Version: 5,
+		Name:    "add target to last run, tasks and history",
+		Postgres: `
+ALTER TABLE pipers_last_run ADD COLUMN IF NOT EXISTS target text not null default '';
+ALTER TABLE pipers_last_run DROP CONSTRAINT IF EXISTS pipers_last_run_pkey;
+ALTER TABLE pipers_last_run ADD PRIMARY KEY (pipe, tbl, target, ident);
+ALTER TABLE pipers_tasks ADD COLUMN IF NOT EXISTS target text not null default '';
+ALTER TABLE pipers_history ADD COLUMN IF NOT EXISTS target text not null default '';
+`,
+		// sqlite can not change a primary key, the table is rebuilt
+		SQLite: `
+CREATE TABLE pipers_last_run_new (
+	pipe text not null,
+	tbl text not null,
+	target text not null default '',
+	ident text not null,
+	run_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	primary key (pipe, tbl, target, ident)
+);
+INSERT INTO pipers_last_run_new (pipe, tbl, ident, run_at) SELECT pipe, tbl, ident, run_at FROM pipers_last_run;
+DROP TABLE pipers_last_run;
+ALTER TABLE pipers_last_run_new RENAME TO pipers_last_run;
+ALTER TABLE pipers_tasks ADD COLUMN target text not null default '';
+ALTER TABLE pipers_history ADD COLUMN target text not null default '';
+`,
+	},
+	{
+		Version: 6,
+		Name:    "create watermarks",
+		Postgres: `
+CREATE TABLE IF NOT EXISTS pipers_watermarks (
+	pipe text not null,
+	tbl text not null,
+	target text not null,
+	mark TIMESTAMP not null,
+	refreshed_at TIMESTAMP,
+	primary key (pipe, tbl, target)
+);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS pipers_watermarks (
+	pipe text not null,
+	tbl text not null,
+	target text not null,
+	mark TIMESTAMP not null,
+	refreshed_at TIMESTAMP,
+	primary key (pipe, tbl, target)
+);
+`,
+	},
+	{
+		Version: 7,
+		Name:    "create targets",
+		Postgres: `
+CREATE TABLE IF NOT EXISTS pipers_targets (
+	name text primary key,
+	description text not null default '',
+	data jsonb,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS pipers_targets (
+	name text primary key,
+	description text not null default '',
+	data text,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+`,
+	},
+	{
+		// records link to the input records they were produced from
+		Version: 8,
+		Name:    "create lineage",
+		Postgres: `
+ALTER TABLE pipers_tasks ADD COLUMN IF NOT EXISTS task_id text not null default '';
+CREATE TABLE IF NOT EXISTS pipers_lineage (
+	tbl text not null,
+	target text not null,
+	ident text not null,
+	source_tbl text not null,
+	source_ident text not null,
+	pipe text not null,
+	task_id text not null default '',
+	created_at TIMESTAMP DEFAULT NOW(),
+	primary key (tbl, target, ident, source_tbl, source_ident)
+);
+CREATE INDEX IF NOT EXISTS lineage_source_idx ON pipers_lineage (source_tbl, target, source_ident);
+`,
+		SQLite: `
+ALTER TABLE pipers_tasks ADD COLUMN task_id text not null default '';
+CREATE TABLE IF NOT EXISTS pipers_lineage (
+	tbl text not null,
+	target text not null,
+	ident text not null,
+	source_tbl text not null,
+	source_ident text not null,
+	pipe text not null,
+	task_id text not null default '',
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	primary key (tbl, target, ident, source_tbl, source_ident)
+);
+CREATE INDEX IF NOT EXISTS lineage_source_idx ON pipers_lineage (source_tbl, target, source_ident);
+`,
+	},
+	{
+		// records excluded by cascading the exclusion of a root record
+		Version: 9,
+		Name:    "create exclusions",
+		Postgres: `
+CREATE TABLE IF NOT EXISTS pipers_exclusions (
+	tbl text not null,
+	target text not null,
+	ident text not null,
+	root_tbl text not null,
+	root_ident text not null,
+	created_at TIMESTAMP DEFAULT NOW(),
+	primary key (tbl, target, ident, root_tbl, root_ident)
+);
+CREATE INDEX IF NOT EXISTS exclusions_root_idx ON pipers_exclusions (root_tbl, root_ident);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS pipers_exclusions (
+	tbl text not null,
+	target text not null,
+	ident text not null,
+	root_tbl text not null,
+	root_ident text not null,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	primary key (tbl, target, ident, root_tbl, root_ident)
+);
+CREATE INDEX IF NOT EXISTS exclusions_root_idx ON pipers_exclusions (root_tbl, root_ident);
+`,
+	},
+	{
+		// alerts can be exported per target
+		Version: 10,
+		Name:    "add target to alerts",
+		Postgres: `
+ALTER TABLE pipers_alerts ADD COLUMN IF NOT EXISTS target text not null default '';
+`,
+		SQLite: `
+ALTER TABLE pipers_alerts ADD COLUMN target text not null default '';
+`,
+	},
+	{
+		// paused targets are not scheduled
+		Version: 11,
+		Name:    "add paused to targets",
+		Postgres: `
+ALTER TABLE pipers_targets ADD COLUMN IF NOT EXISTS paused boolean not null default false;
+`,
+		SQLite: `
+ALTER TABLE pipers_targets ADD COLUMN paused boolean not null default false;
+`,
+	},
+	{
+		// pipes can be paused without removing their definition
+		Version: 12,
+		Name:    "create pipes",
+		Postgres: `
+CREATE TABLE IF NOT EXISTS pipers_pipes (
+	name text primary key,
+	paused boolean not null default false,
+	updated_at TIMESTAMP DEFAULT NOW()
+);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS pipers_pipes (
+	name text primary key,
+	paused boolean not null default false,
+	updated_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+`,
+	},
+	{
+		// records are not marked as removed right after a resume
+		Version: 13,
+		Name:    "add resumed_at to pipes and targets",
+		Postgres: `
+ALTER TABLE pipers_pipes ADD COLUMN IF NOT EXISTS resumed_at TIMESTAMP;
+ALTER TABLE pipers_targets ADD COLUMN IF NOT EXISTS resumed_at TIMESTAMP;
+`,
+		SQLite: `
+ALTER TABLE pipers_pipes ADD COLUMN resumed_at TIMESTAMP;
+ALTER TABLE pipers_targets ADD COLUMN resumed_at TIMESTAMP;
+`,
+	},
+}
+
+// TABLE_MIGRATIONS are applied to every data table, %[1]v is replaced
+// with the name of the table. Each table keeps its own version.
+var TABLE_MIGRATIONS = []Migration{
+	{
+		Version: 1,
+		Name:    "create data table",
+		Postgres: `
+CREATE TABLE IF NOT EXISTS %[1]v (
+	id text primary key,
+	asset text not null,
+	target text not null,
+	pipe text not null,
+	exclude boolean default false,
+	data jsonb,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS %[1]v_asset_idx ON %[1]v (asset);
+CREATE INDEX IF NOT EXISTS %[1]v_target_idx ON %[1]v (target);
+`,
+		SQLite: `
+CREATE TABLE IF NOT EXISTS %[1]v (
+	id text primary key,
+	asset text not null,
+	target text not null,
+	pipe text not null,
+	exclude boolean default false,
+	data text,
+	created_at TIMESTAMP DEFAULT (strftime('%%Y-%%m-%%d %%H:%%M:%%f', 'now'))
+);
+CREATE INDEX IF NOT EXISTS %[1]v_asset_idx ON %[1]v (asset);
+CREATE INDEX IF NOT EXISTS %[1]v_target_idx ON %[1]v (target);
+`,
+	},
+	{
+		Version: 2,
+		Name:    "add active and last_seen",
+		Postgres: `
+ALTER TABLE %[1]v ADD COLUMN IF NOT EXISTS active boolean default true;
+ALTER TABLE %[1]v ADD COLUMN IF NOT EXISTS last_seen TIMESTAMP DEFAULT NOW();
+`,
+		// sqlite does not allow non-constant defaults for added columns,
+		// a missing last_seen falls back to created_at
+		SQLite: `
+ALTER TABLE %[1]v ADD COLUMN active boolean default true;
+ALTER TABLE %[1]v ADD COLUMN last_seen TIMESTAMP;
+`,
+	},
+	{
+		// tasks do not know their input table, records of all tables
+		// with a matching ident are seeded. Tasks of as_file pipes are
+		// keyed by target name, they are seeded by version 8.
+		Version: 3,
+		Name:    "seed last run from tasks",
+		Postgres: `
+INSERT INTO pipers_last_run (pipe, tbl, ident, run_at)
+SELECT T.pipe, '%[1]v', T.ident, MAX(T.created_at)
+FROM pipers_tasks T JOIN %[1]v A ON A.id = T.ident
+WHERE T.ident NOT IN (SELECT target FROM %[1]v)
+GROUP BY T.pipe, T.ident
+ON CONFLICT DO NOTHING;
+`,
+		SQLite: `
+INSERT INTO pipers_last_run (pipe, tbl, ident, run_at)
+SELECT T.pipe, '%[1]v', T.ident, MAX(T.created_at)
+FROM pipers_tasks T JOIN %[1]v A ON A.id = T.ident
+WHERE T.ident NOT IN (SELECT target FROM %[1]v)
+GROUP BY T.pipe, T.ident
+ON CONFLICT DO NOTHING;
+`,
+	},
+	{
+		// tasks recorded before, the first table containing the ident
+		// is assumed as their source
+		Version: 4,
+		Name:    "set tbl of tasks",
+		Postgres: `
+UPDATE pipers_tasks SET tbl = '%[1]v'
+WHERE tbl = '' AND ident IN (SELECT id FROM %[1]v)
+AND ident NOT IN (SELECT target FROM %[1]v);
+`,
+		SQLite: `
+UPDATE pipers_tasks SET tbl = '%[1]v'
+WHERE tbl = '' AND ident IN (SELECT id FROM %[1]v)
+AND ident NOT IN (SELECT target FROM %[1]v);
+`,
+	},
+	{
+		// last runs recorded before, the run applies to all
+		// records with the ident
+		Version: 5,
+		Name:    "set target of last run",
+		Postgres: `
+INSERT INTO pipers_last_run (pipe, tbl, target, ident, run_at)
+SELECT L.pipe, L.tbl, A.target, L.ident, L.run_at
+FROM pipers_last_run L JOIN %[1]v A ON A.id = L.ident
+WHERE L.tbl = '%[1]v' AND L.target = ''
+ON CONFLICT DO NOTHING;
+DELETE FROM pipers_last_run WHERE tbl = '%[1]v' AND target = '';
+`,
+		SQLite: `
+INSERT INTO pipers_last_run (pipe, tbl, target, ident, run_at)
+SELECT L.pipe, L.tbl, A.target, L.ident, L.run_at
+FROM pipers_last_run L JOIN %[1]v A ON A.id = L.ident
+WHERE L.tbl = '%[1]v' AND L.target = ''
+ON CONFLICT DO NOTHING;
+DELETE FROM pipers_last_run WHERE tbl = '%[1]v' AND target = '';
+`,
+	},
+	{
+		// targets were a distinct over the data tables before
+		Version: 6,
+		Name:    "register targets",
+		Postgres: `
+INSERT INTO pipers_targets (name)
+SELECT DISTINCT target FROM %[1]v
+ON CONFLICT DO NOTHING;
+`,
+		SQLite: `
+INSERT INTO pipers_targets (name)
+SELECT DISTINCT target FROM %[1]v WHERE true
+ON CONFLICT DO NOTHING;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "add asset_type",
+		Postgres: `
+ALTER TABLE %[1]v ADD COLUMN IF NOT EXISTS asset_type text not null default '';
+`,
+		SQLite: `
+ALTER TABLE %[1]v ADD COLUMN asset_type text not null default '';
+`,
+	},
+	{
+		// tasks of as_file pipes recorded before are keyed by target
+		// name, they are moved to the target source of the first table
+		// containing the target
+		Version: 8,
+		Name:    "seed last run of as_file tasks",
+		Postgres: `
+UPDATE pipers_tasks SET tbl = 'target:%[1]v', target = ident
+WHERE tbl = '' AND ident IN (SELECT target FROM %[1]v);
+INSERT INTO pipers_last_run (pipe, tbl, target, ident, run_at)
+SELECT pipe, tbl, ident, ident, MAX(created_at)
+FROM pipers_tasks
+WHERE tbl = 'target:%[1]v'
+GROUP BY pipe, tbl, ident
+ON CONFLICT DO NOTHING;
+`,
+		SQLite: `
+UPDATE pipers_tasks SET tbl = 'target:%[1]v', target = ident
+WHERE tbl = '' AND ident IN (SELECT target FROM %[1]v);
+INSERT INTO pipers_last_run (pipe, tbl, target, ident, run_at)
+SELECT pipe, tbl, ident, ident, MAX(created_at)
+FROM pipers_tasks
+WHERE tbl = 'target:%[1]v'
+GROUP BY pipe, tbl, ident
+ON CONFLICT DO NOTHING;
+`,
+	},
+}
+
+const SQL_CREATE_SCHEMA_VERSION = `
+CREATE TABLE IF NOT EXISTS pipers_schema_version (
+	scope text not null,
+	version integer not null,
+	name text not null,
+	applied_at TIMESTAMP DEFAULT NOW(),
+	primary key (scope, version)
+);
+`
+
+const SQLITE_CREATE_SCHEMA_VERSION = `
+CREATE TABLE IF NOT EXISTS pipers_schema_version (
+	scope text not null,
+	version integer not null,
+	name text not null,
+	applied_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+	primary key (scope, version)
+);
 `
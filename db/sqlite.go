@@ -0,0 +1,1085 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"modernc.org/sqlite"
+)
+
+const SQLITE_SCHEME = "sqlite://"
+
+// SQLITE_DRIVER is the pure go sqlite driver, it does not need cgo so
+// released binaries support sqlite. Functions used by filters are
+// registered for all its connections.
+const SQLITE_DRIVER = "sqlite"
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		pattern, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("regexp: pattern must be text")
+		}
+		return sqliteRegexp(pattern, args[1])
+	})
+}
+
+var sqliteRegexps sync.Map
+
+// sqliteRegexp implements the REGEXP operator, compiled expressions
+// are cached because the function is called for every row. Like in
+// postgres, matching null results in null.
+func sqliteRegexp(pattern string, value interface{}) (interface{}, error) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		if v == nil {
+			return nil, nil
+		}
+		s = string(v)
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if re, ok := sqliteRegexps.Load(pattern); ok {
+		return re.(*regexp.Regexp).MatchString(s), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sqliteRegexps.Store(pattern, re)
+
+	return re.MatchString(s), nil
+}
+
+// sqliteTimeFormat matches the format used by the created_at defaults,
+// so timestamps can be compared as strings
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+type SQLiteService struct {
+	DB            *sql.DB
+	SkipTaskAudit bool // do not log tasks in pipers_tasks
+	Layout        Layout
+	Scope         Scope
+}
+
+// IsSqlite reports whether the database uri selects the sqlite backend
+func IsSqlite(uri string) bool {
+	return strings.HasPrefix(uri, SQLITE_SCHEME)
+}
+
+// InitSqlite opens the database file referenced by an uri
+// like sqlite://./pipers.db
+func InitSqlite(uri string) (*sql.DB, error) {
+	path := strings.TrimPrefix(uri, SQLITE_SCHEME)
+	if path == "" {
+		return nil, fmt.Errorf("unable to parse DB URI")
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+
+	db, err := sql.Open(SQLITE_DRIVER, fmt.Sprintf("file:%v%v_pragma=busy_timeout(5000)", path, sep))
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only supports a single writer, workers share one connection
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// SetupSqlite migrates the essential tables and all passed data tables
+// using the default layout
+func SetupSqlite(db *sql.DB, tables []string) error {
+	return (&SQLiteService{DB: db}).Migrate(tables)
+}
+
+func (d *SQLiteService) Migrate(tables []string) error {
+	status, err := d.MigrationStatus(tables)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range status {
+		for _, m := range s.Pending {
+			if err := d.applyMigration(s.Scope, m); err != nil {
+				return fmt.Errorf("migration %v (%v) of %v failed: %v", m.Version, m.Name, s.Scope, err)
+			}
+
+			log.WithFields(log.Fields{
+				"scope":   s.Scope,
+				"version": m.Version,
+			}).Infof("applied migration '%v'", m.Name)
+		}
+	}
+
+	return d.applyLayout(tables)
+}
+
+// applyLayout works like PostgresService.applyLayout. sqlite can not
+// change a primary key, so the table is rebuilt with the same columns.
+func (d *SQLiteService) applyLayout(tables []string) error {
+	for _, table := range tables {
+		columns, err := d.tableColumns(table)
+		if err != nil {
+			return fmt.Errorf("reading columns of %v failed: %v", table, err)
+		}
+
+		keys := 0
+		for _, c := range columns {
+			if c.pk > 0 {
+				keys++
+			}
+		}
+
+		byTarget := d.Layout.KeyedByTarget(table)
+		if byTarget == (keys == 2) {
+			continue
+		}
+
+		key := "id"
+		if byTarget {
+			key = "target, id"
+		}
+
+		if err := d.rebuildTable(table, columns, key); err != nil {
+			return fmt.Errorf("changing primary key of %v to (%v) failed: %v", table, key, err)
+		}
+
+		log.WithFields(log.Fields{
+			"table": table,
+		}).Infof("changed primary key to (%v)", key)
+	}
+
+	return nil
+}
+
+type sqliteColumn struct {
+	name, typ string
+	notNull   bool
+	dflt      sql.NullString
+	pk        int
+}
+
+func (d *SQLiteService) tableColumns(table string) ([]sqliteColumn, error) {
+	rows, err := d.DB.Query(fmt.Sprintf("PRAGMA table_info(%v)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []sqliteColumn
+	for rows.Next() {
+		var c sqliteColumn
+		var cid int
+		if err := rows.Scan(&cid, &c.name, &c.typ, &c.notNull, &c.dflt, &c.pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+
+	return columns, rows.Err()
+}
+
+func (d *SQLiteService) rebuildTable(table string, columns []sqliteColumn, key string) error {
+	indexes, err := d.retrieveStrings("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", table)
+	if err != nil {
+		return err
+	}
+
+	var definitions []string
+	for _, c := range columns {
+		definition := fmt.Sprintf("%v %v", c.name, c.typ)
+		if c.notNull || c.name == "id" {
+			definition += " not null"
+		}
+		if c.dflt.Valid {
+			definition += " default (" + c.dflt.String + ")"
+		}
+		definitions = append(definitions, definition)
+	}
+	definitions = append(definitions, fmt.Sprintf("primary key (%v)", key))
+
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE %v_rebuild (%v)", table, strings.Join(definitions, ", ")),
+		fmt.Sprintf("INSERT INTO %[1]v_rebuild SELECT * FROM %[1]v", table),
+		fmt.Sprintf("DROP TABLE %v", table),
+		fmt.Sprintf("ALTER TABLE %[1]v_rebuild RENAME TO %[1]v", table),
+	}
+	statements = append(statements, indexes...)
+	statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]v_id_idx ON %[1]v (id)", table))
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *SQLiteService) applyMigration(scope string, m Migration) error {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitStatements(migrationSQL(scope, m.SQLite)) {
+		// sqlite has no ADD COLUMN IF NOT EXISTS, columns may already
+		// exist in databases created before versioning
+		if _, err := tx.Exec(statement); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO pipers_schema_version (scope, version, name) VALUES (?, ?, ?)", scope, m.Version, m.Name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *SQLiteService) MigrationStatus(tables []string) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+
+	if _, err := d.DB.Exec(SQLITE_CREATE_SCHEMA_VERSION); err != nil {
+		return status, fmt.Errorf("unable to create schema version table: %v", err)
+	}
+
+	for _, scope := range migrationScopes(tables) {
+		var version int
+		err := d.DB.QueryRow("SELECT COALESCE(MAX(version), 0) FROM pipers_schema_version WHERE scope = ?", scope).Scan(&version)
+		if err != nil {
+			return status, err
+		}
+
+		status = append(status, migrationStatus(scope, version))
+	}
+
+	return status, nil
+}
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// AddTask works like PostgresService.AddTask
+func (d *SQLiteService) AddTask(t Task) error {
+	_, err := d.DB.Exec(
+		`INSERT INTO pipers_last_run (pipe, tbl, target, ident, run_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (pipe, tbl, target, ident) DO UPDATE SET run_at = excluded.run_at`,
+		t.Pipe, t.Table, t.Target, t.Ident, sqliteTime(time.Now()),
+	)
+
+	if err == nil && !d.SkipTaskAudit {
+		_, err = d.DB.Exec("INSERT INTO pipers_tasks (pipe, tbl, target, ident, task_id, note) VALUES (?, ?, ?, ?, ?, ?)", t.Pipe, t.Table, t.Target, t.Ident, t.TaskId, t.Note)
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{
+			"task":  t,
+			"error": err,
+		}).Error("adding task failed")
+		return err
+	}
+
+	return nil
+}
+
+// Due works like PostgresService.Due
+func (d *SQLiteService) Due(pipe, table, target string, idents []string, interval time.Duration) ([]string, error) {
+	recent := make(map[string]struct{})
+	since := sqliteTime(time.Now().Add(-interval))
+
+	for _, batch := range batches(idents, DUE_BATCH_SIZE) {
+		args := []interface{}{pipe, table, target, since}
+		for _, ident := range batch {
+			args = append(args, ident)
+		}
+
+		query := fmt.Sprintf(
+			"SELECT ident FROM pipers_last_run WHERE pipe = ? AND tbl = ? AND target = ? AND run_at > ? AND ident IN (?%v)",
+			strings.Repeat(", ?", len(batch)-1),
+		)
+
+		found, err := d.retrieveStrings(query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ident := range found {
+			recent[ident] = struct{}{}
+		}
+	}
+
+	return withoutIdents(idents, recent), nil
+}
+
+// Retrieve works like PostgresService.Retrieve
+func (d *SQLiteService) Retrieve(table string, pipeName string, filter Filter, interval time.Duration) ([]Data, error) {
+	query := fmt.Sprintf(`
+		SELECT
+			A.id, A.asset, A.target, A.data, A.asset_type
+		FROM
+			%v A
+			LEFT JOIN pipers_last_run L
+			ON L.pipe = ? AND L.tbl = ? AND L.target = A.target AND L.ident = A.id AND L.run_at > ?
+		WHERE L.ident IS NULL AND A.exclude = false AND A.active = true
+	`, table)
+
+	args := []interface{}{pipeName, table, sqliteTime(time.Now().Add(-interval))}
+
+	if where, filterArgs := filter.compile(&filterCompiler{sqlite: true, alias: "A."}); where != "" {
+		query += " AND " + where
+		args = append(args, filterArgs...)
+	}
+
+	return d.query(query, args...)
+}
+
+func (d *SQLiteService) RetrieveByTarget(table string, filter Filter, target string) ([]Data, error) {
+	query := fmt.Sprintf("SELECT id, asset, target, data, asset_type FROM %v WHERE target = ? AND exclude = false AND active = true", table)
+	args := []interface{}{target}
+
+	if where, filterArgs := filter.compile(&filterCompiler{sqlite: true}); where != "" {
+		query += " AND " + where
+		args = append(args, filterArgs...)
+	}
+
+	return d.query(query, args...)
+}
+
+// RetrieveIncrement works like PostgresService.RetrieveIncrement
+func (d *SQLiteService) RetrieveIncrement(table, pipeName, target string, filter Filter, refresh time.Duration) (Increment, error) {
+	inc := Increment{Mark: sqliteTime(time.Now().Add(-INCREMENT_LAG))}
+
+	args := []interface{}{pipeName, table, inc.Mark}
+
+	from := fmt.Sprintf(`
+		%v A
+		LEFT JOIN pipers_watermarks W
+		ON W.pipe = ? AND W.tbl = ? AND W.target = A.target
+	`, table)
+	where := "A.exclude = false AND A.active = true AND A.created_at <= ?"
+
+	if target != "" {
+		args = append(args, target)
+		where += " AND A.target = ?"
+	}
+
+	full := "W.mark IS NULL"
+	if refresh > 0 {
+		args = append(args, sqliteTime(time.Now().Add(-refresh)))
+		full += " OR W.refreshed_at IS NULL OR W.refreshed_at < ?"
+	}
+
+	var err error
+	inc.Refreshed, err = d.retrieveStrings(fmt.Sprintf("SELECT DISTINCT A.target FROM %v WHERE %v AND (%v)", from, where, full), args...)
+	if err != nil {
+		return inc, err
+	}
+
+	query := fmt.Sprintf("SELECT A.id, A.asset, A.target, A.data, A.asset_type FROM %v WHERE %v AND (%v OR A.created_at > W.mark)", from, where, full)
+
+	if filterSQL, filterArgs := filter.compile(&filterCompiler{sqlite: true, alias: "A."}); filterSQL != "" {
+		query += " AND " + filterSQL
+		args = append(args, filterArgs...)
+	}
+
+	inc.Rows, err = d.query(query+" ORDER BY A.created_at", args...)
+	return inc, err
+}
+
+// CommitIncrement works like PostgresService.CommitIncrement
+func (d *SQLiteService) CommitIncrement(table, pipeName string, inc Increment) error {
+	for _, target := range inc.targets() {
+		var refreshed interface{}
+		if inc.refreshed(target) {
+			refreshed = sqliteTime(time.Now())
+		}
+
+		_, err := d.DB.Exec(
+			`INSERT INTO pipers_watermarks (pipe, tbl, target, mark, refreshed_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (pipe, tbl, target) DO UPDATE SET
+				mark = excluded.mark,
+				refreshed_at = COALESCE(excluded.refreshed_at, pipers_watermarks.refreshed_at)`,
+			pipeName, table, target, inc.Mark, refreshed,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// query reads all data rows at once, because the single connection
+// must not be held while the caller issues further statements
+func (d *SQLiteService) query(query string, args ...interface{}) ([]Data, error) {
+	rows, err := d.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Data
+	for rows.Next() {
+		var data Data
+		var raw sql.NullString
+
+		if err := rows.Scan(&data.Id, &data.Asset, &data.Target, &raw, &data.AssetType); err != nil {
+			return nil, err
+		}
+
+		if raw.Valid && raw.String != "" {
+			if err := json.Unmarshal([]byte(raw.String), &data.Data); err != nil {
+				return nil, fmt.Errorf("decoding data of %v failed: %v", data.Id, err)
+			}
+		}
+
+		result = append(result, data)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// AddTarget works like PostgresService.AddTarget
+func (d *SQLiteService) AddTarget(t Target) error {
+	var data interface{}
+	if t.Data != nil {
+		encoded, err := json.Marshal(t.Data)
+		if err != nil {
+			return err
+		}
+		data = string(encoded)
+	}
+
+	_, err := d.DB.Exec(
+		`INSERT INTO pipers_targets (name, description, data) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET description = excluded.description, data = excluded.data`,
+		t.Name, t.Description, data,
+	)
+	return err
+}
+
+func (d *SQLiteService) RetrieveTargets() ([]string, error) {
+	return d.retrieveStrings("SELECT name FROM pipers_targets ORDER BY name")
+}
+
+// ListTargets works like PostgresService.ListTargets
+func (d *SQLiteService) ListTargets() ([]Target, error) {
+	rows, err := d.DB.Query("SELECT name, description, data, paused, created_at FROM pipers_targets ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []Target
+	for rows.Next() {
+		var t Target
+		var raw sql.NullString
+
+		if err := rows.Scan(&t.Name, &t.Description, &raw, &t.Paused, &t.Created); err != nil {
+			return nil, err
+		}
+
+		if raw.Valid && raw.String != "" {
+			if err := json.Unmarshal([]byte(raw.String), &t.Data); err != nil {
+				return nil, fmt.Errorf("decoding data of target %v failed: %v", t.Name, err)
+			}
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, rows.Err()
+}
+
+// PauseTarget works like PostgresService.PauseTarget
+func (d *SQLiteService) PauseTarget(name string, paused bool) error {
+	res, err := d.DB.Exec(
+		"UPDATE pipers_targets SET paused = ?, resumed_at = CASE WHEN paused AND NOT ? THEN ? ELSE resumed_at END WHERE name = ?",
+		paused, paused, sqliteTime(time.Now()), name,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("target %v not found", name)
+	}
+	return nil
+}
+
+// PausePipe works like PostgresService.PausePipe
+func (d *SQLiteService) PausePipe(name string, paused bool) error {
+	_, err := d.DB.Exec(
+		`INSERT INTO pipers_pipes (name, paused) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET paused = excluded.paused, updated_at = ?,
+		resumed_at = CASE WHEN pipers_pipes.paused AND NOT excluded.paused THEN ? ELSE pipers_pipes.resumed_at END`,
+		name, paused, sqliteTime(time.Now()), sqliteTime(time.Now()),
+	)
+	return err
+}
+
+// RetrievePaused works like PostgresService.RetrievePaused
+func (d *SQLiteService) RetrievePaused() (Paused, error) {
+	paused := newPaused()
+
+	for query, names := range map[string]map[string]bool{
+		"SELECT name FROM pipers_pipes WHERE paused":   paused.Pipes,
+		"SELECT name FROM pipers_targets WHERE paused": paused.Targets,
+	} {
+		found, err := d.retrieveStrings(query)
+		if err != nil {
+			return paused, err
+		}
+		for _, name := range found {
+			names[name] = true
+		}
+	}
+
+	return paused, nil
+}
+
+// RemoveTarget works like PostgresService.RemoveTarget
+func (d *SQLiteService) RemoveTarget(name string, tables []string) (map[string]int64, error) {
+	deleted := make(map[string]int64)
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM pipers_targets WHERE name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("target %v not found", name)
+	}
+
+	for _, table := range append(append([]string{}, tables...), TARGET_TABLES...) {
+		res, err := tx.Exec(fmt.Sprintf("DELETE FROM %v WHERE target = ?", table), name)
+		if err != nil {
+			return nil, fmt.Errorf("deleting from %v failed: %v", table, err)
+		}
+		deleted[table], _ = res.RowsAffected()
+	}
+
+	return deleted, tx.Commit()
+}
+
+// CountRecords works like PostgresService.CountRecords
+func (d *SQLiteService) CountRecords(table string) (map[string]int64, error) {
+	rows, err := d.DB.Query(fmt.Sprintf("SELECT target, COUNT(*) FROM %v GROUP BY target", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var target string
+		var n int64
+		if err := rows.Scan(&target, &n); err != nil {
+			return nil, err
+		}
+		counts[target] = n
+	}
+
+	return counts, rows.Err()
+}
+
+// RetrieveBlocked works like PostgresService.RetrieveBlocked
+func (d *SQLiteService) RetrieveBlocked(target string) ([]string, error) {
+	var parts []string
+	var args []interface{}
+
+	for _, table := range d.Scope.exclusionTables() {
+		part := fmt.Sprintf("SELECT asset FROM %v WHERE exclude = true", table)
+		if d.Layout.KeyedByTarget(table) {
+			part += " AND target = ?"
+			args = append(args, target)
+		}
+		parts = append(parts, part)
+	}
+
+	return d.retrieveStrings(strings.Join(parts, " UNION "), args...)
+}
+
+func (d *SQLiteService) retrieveStrings(query string, args ...interface{}) ([]string, error) {
+	var result []string
+
+	rows, err := d.DB.Query(query, args...)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s string
+		if err = rows.Scan(&s); err != nil {
+			return result, err
+		}
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+func (d *SQLiteService) Save(table, pipe, id string, data Data, result map[string]interface{}, opts SaveOptions) (SaveResult, error) {
+	var res SaveResult
+
+	query := fmt.Sprintf(`
+		INSERT INTO %v (id, asset, target, pipe, data, asset_type) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING;
+	`, table)
+
+	// if a asset is provided, use the provided one if valid
+	asset := data.Asset
+	if v, ok := result["asset"].(string); ok && v != "" {
+		asset = v
+	}
+
+	delete(result, "asset")
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return res, err
+	}
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Exec(query, id, asset, data.Target, pipe, string(encoded), opts.AssetType)
+	if err != nil {
+		return res, err
+	}
+
+	if s := opts.Source; s != nil {
+		_, err := tx.Exec(
+			`INSERT INTO pipers_lineage (tbl, target, ident, source_tbl, source_ident, pipe, task_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			table, data.Target, id, s.Table, s.Ident, pipe, s.TaskId, sqliteTime(time.Now()),
+		)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	affected, err := insert.RowsAffected()
+	if err != nil {
+		return res, err
+	}
+
+	if affected == 1 {
+		if _, err := tx.Exec("INSERT INTO pipers_targets (name) VALUES (?) ON CONFLICT DO NOTHING", data.Target); err != nil {
+			return res, err
+		}
+
+		res.Inserted = true
+		return res, tx.Commit()
+	}
+
+	where, whereArgs := d.Layout.recordWhere(table, id, data.Target, func(int) string { return "?" })
+
+	// compare tracked fields with the stored version
+	var raw sql.NullString
+	var active sql.NullBool
+	if err := tx.QueryRow(fmt.Sprintf("SELECT data, active FROM %v WHERE %v", table, where), whereArgs...).Scan(&raw, &active); err != nil {
+		return res, err
+	}
+
+	var stored map[string]interface{}
+	if raw.Valid && raw.String != "" {
+		if err := json.Unmarshal([]byte(raw.String), &stored); err != nil {
+			return res, err
+		}
+	}
+
+	res.Reactivated = active.Valid && !active.Bool
+	res.Changes = diffFields(stored, result, opts.Track)
+
+	if len(res.Changes) > 0 {
+		if _, err := tx.Exec("INSERT INTO pipers_history (tbl, target, ident, pipe, data) VALUES (?, ?, ?, ?, ?)", table, data.Target, id, pipe, raw); err != nil {
+			return res, err
+		}
+
+		raw = sql.NullString{String: string(encoded), Valid: true}
+		res.Updated = true
+	}
+
+	// the record was seen again
+	update := fmt.Sprintf("UPDATE %v SET data = ?, asset_type = COALESCE(NULLIF(?, ''), asset_type), active = true, last_seen = ? WHERE %v", table, where)
+	if _, err := tx.Exec(update, append([]interface{}{raw, opts.AssetType, sqliteTime(time.Now())}, whereArgs...)...); err != nil {
+		return res, err
+	}
+
+	return res, tx.Commit()
+}
+
+// Lineage works like PostgresService.Lineage
+func (d *SQLiteService) Lineage(table, target, ident string, descendants bool) ([]Lineage, error) {
+	query := fmt.Sprintf(lineageWalk(descendants), "?1", "?2", "?3")
+
+	rows, err := d.DB.Query(query, table, ident, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edges []Lineage
+	for rows.Next() {
+		var l Lineage
+		if err := rows.Scan(&l.Table, &l.Target, &l.Ident, &l.SourceTable, &l.SourceIdent, &l.Pipe, &l.TaskId, &l.Created); err != nil {
+			return nil, err
+		}
+		edges = append(edges, l)
+	}
+
+	return edges, rows.Err()
+}
+
+// Exclude works like PostgresService.Exclude
+func (d *SQLiteService) Exclude(table, target, ident string, opts ExcludeOptions) ([]Record, error) {
+	edges, err := d.Lineage(table, target, ident, true)
+	if err != nil {
+		return nil, err
+	}
+
+	covered, err := d.coveredEdges(table, target, ident)
+	if err != nil {
+		return nil, err
+	}
+	edges = append(edges, covered...)
+
+	question := func(int) string { return "?" }
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, args := rootWhere(ident, target, question)
+	res, err := tx.Exec(fmt.Sprintf("UPDATE %v SET exclude = true WHERE %v", table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("record %v not found in %v", ident, table)
+	}
+
+	var affected []Record
+	for _, r := range descendants(edges, Record{Table: table, Target: target, Ident: ident}) {
+		where, args := d.Layout.recordWhere(r.Table, r.Ident, r.Target, question)
+
+		if opts.Delete {
+			res, err := tx.Exec(fmt.Sprintf("DELETE FROM %v WHERE %v", r.Table, where), args...)
+			if err != nil {
+				return nil, err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				affected = append(affected, r)
+			}
+
+			_, err = tx.Exec("DELETE FROM pipers_exclusions WHERE tbl = ? AND target = ? AND ident = ?", r.Table, r.Target, r.Ident)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var excluded, cascaded bool
+		err := tx.QueryRow(fmt.Sprintf("SELECT COALESCE(exclude, false) FROM %v WHERE %v", r.Table, where), args...).Scan(&excluded)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		err = tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM pipers_exclusions WHERE tbl = ? AND target = ? AND ident = ?)",
+			r.Table, r.Target, r.Ident,
+		).Scan(&cascaded)
+		if err != nil {
+			return nil, err
+		}
+
+		// excluded independently of a cascade
+		if excluded && !cascaded {
+			continue
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO pipers_exclusions (tbl, target, ident, root_tbl, root_ident, created_at)
+			VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			r.Table, r.Target, r.Ident, table, ident, sqliteTime(time.Now()),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if !excluded {
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %v SET exclude = true WHERE %v", r.Table, where), args...); err != nil {
+				return nil, err
+			}
+			affected = append(affected, r)
+		}
+	}
+
+	return affected, tx.Commit()
+}
+
+// coveredEdges works like PostgresService.coveredEdges
+func (d *SQLiteService) coveredEdges(table, target, ident string) ([]Lineage, error) {
+	question := func(int) string { return "?" }
+
+	where, args := rootWhere(ident, target, question)
+	roots, err := d.query(fmt.Sprintf("SELECT id, asset, target, data, asset_type FROM %v WHERE %v", table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	asset := func(r Record) string {
+		where, args := d.Layout.recordWhere(r.Table, r.Ident, r.Target, question)
+
+		var a string
+		d.DB.QueryRow(fmt.Sprintf("SELECT asset FROM %v WHERE %v", r.Table, where), args...).Scan(&a)
+		return a
+	}
+
+	var edges []Lineage
+	for _, root := range roots {
+		covered, err := coveredEdges(d, Record{Table: table, Target: root.Target, Ident: ident}, root.Asset, asset)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, covered...)
+	}
+
+	return edges, nil
+}
+
+// Include works like PostgresService.Include
+func (d *SQLiteService) Include(table, target, ident string) ([]Record, error) {
+	question := func(int) string { return "?" }
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, args := rootWhere(ident, target, question)
+	res, err := tx.Exec(fmt.Sprintf("UPDATE %v SET exclude = false WHERE %v", table, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("record %v not found in %v", ident, table)
+	}
+
+	rows, err := tx.Query(
+		`DELETE FROM pipers_exclusions WHERE root_tbl = ?1 AND root_ident = ?2 AND (?3 = '' OR target = ?3)
+		RETURNING tbl, target, ident`,
+		table, ident, target,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var cascaded []Record
+	for rows.Next() {
+		var r Record
+		if err := rows.Scan(&r.Table, &r.Target, &r.Ident); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cascaded = append(cascaded, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var affected []Record
+	for _, r := range cascaded {
+		var remaining bool
+		err := tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM pipers_exclusions WHERE tbl = ? AND target = ? AND ident = ?)",
+			r.Table, r.Target, r.Ident,
+		).Scan(&remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		if remaining {
+			continue
+		}
+
+		where, args := d.Layout.recordWhere(r.Table, r.Ident, r.Target, question)
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %v SET exclude = false WHERE %v", r.Table, where), args...); err != nil {
+			return nil, err
+		}
+		affected = append(affected, r)
+	}
+
+	return affected, tx.Commit()
+}
+
+// Export works like PostgresService.Export
+func (d *SQLiteService) Export(table string, q ExportQuery, fn func(Row) error) error {
+	query, args, err := exportQuery(table, q, &filterCompiler{sqlite: true})
+	if err != nil {
+		return err
+	}
+
+	rows, err := d.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row Row
+
+		switch table {
+		case "pipers_alerts":
+			var a Alert
+			if err := rows.Scan(&a.Type, &a.Pipe, &a.Target, &a.Ident, &a.Message, &a.Created); err != nil {
+				return err
+			}
+			row = alertRow(a)
+		case "pipers_tasks":
+			var t Task
+			if err := rows.Scan(&t.Pipe, &t.Table, &t.Target, &t.Ident, &t.TaskId, &t.Note, &t.Created); err != nil {
+				return err
+			}
+			row = taskRow(t)
+		default:
+			var data Data
+			var exclude, active bool
+			var created time.Time
+			var lastSeen sql.NullTime
+			var raw sql.NullString
+			if err := rows.Scan(&data.Id, &data.Asset, &data.AssetType, &data.Target, &data.Pipe, &exclude, &active, &created, &lastSeen, &raw); err != nil {
+				return err
+			}
+
+			if raw.Valid && raw.String != "" {
+				if err := json.Unmarshal([]byte(raw.String), &data.Data); err != nil {
+					return fmt.Errorf("decoding data of %v failed: %v", data.Id, err)
+				}
+			}
+
+			var seen *time.Time
+			if lastSeen.Valid {
+				seen = &lastSeen.Time
+			}
+			row = recordRow(data, exclude, active, created, seen)
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// MarkRemoved works like PostgresService.MarkRemoved
+func (d *SQLiteService) MarkRemoved(table, pipe string, after time.Duration) ([]Data, error) {
+	query := fmt.Sprintf(`
+		UPDATE %v SET active = false
+		WHERE pipe = ? AND active = true AND COALESCE(last_seen, created_at) < ?
+		AND target NOT IN (SELECT name FROM pipers_targets WHERE paused OR resumed_at > ?)
+		AND NOT EXISTS (SELECT 1 FROM pipers_pipes WHERE name = ? AND (paused OR resumed_at > ?))
+		RETURNING id, asset, target, data, asset_type
+	`, table)
+
+	before := sqliteTime(time.Now().Add(-after))
+	return d.query(query, pipe, before, before, pipe, before)
+}
+
+func (d *SQLiteService) SaveAlert(pipe, target, id, msg, alertType string) error {
+
+	query := `INSERT INTO pipers_alerts (type, pipe, target, ident, message) VALUES (?, ?, ?, ?, ?)`
+
+	if _, err := d.DB.Exec(query, alertType, pipe, target, id, msg); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"pipe": pipe,
+		"id":   id,
+	}).Debug("created alert")
+
+	return nil
+}
+
+// Prune works like PostgresService.Prune
+func (d *SQLiteService) Prune(table, target string, maxAge time.Duration, dryRun bool) (int64, error) {
+	column := "COALESCE(last_seen, created_at)"
+	if isEssential(table) {
+		column = "created_at"
+	}
+
+	where := fmt.Sprintf("%v < ?", column)
+	args := []interface{}{sqliteTime(time.Now().Add(-maxAge))}
+
+	if target != "" {
+		where += " AND target = ?"
+		args = append(args, target)
+	}
+
+	if dryRun {
+		var n int64
+		err := d.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %v WHERE %v", table, where), args...).Scan(&n)
+		return n, err
+	}
+
+	deleted, err := d.DB.Exec(fmt.Sprintf("DELETE FROM %v WHERE %v", table, where), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted.RowsAffected()
+}
@@ -0,0 +1,561 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSqlite(t *testing.T) (*SQLiteService, *sql.DB) {
+	t.Helper()
+
+	db, err := InitSqlite(SQLITE_SCHEME + filepath.Join(t.TempDir(), "pipers.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := SetupSqlite(db, TABLES); err != nil {
+		t.Fatal(err)
+	}
+
+	return &SQLiteService{DB: db}, db
+}
+
+func TestSqliteRetrieve(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	ident := "robinverton.de"
+	target := "rv"
+	filter := make(map[string]string)
+	threshold := make(map[string]string)
+
+	if _, err := db.Exec("INSERT INTO domains (id, asset, target, pipe) VALUES (?, ?, ?, 'manual')", ident, ident, target); err != nil {
+		t.Fatal(err)
+	}
+
+	// excluded asset
+	if _, err := db.Exec("INSERT INTO domains (id, asset, target, pipe, exclude) VALUES (?, ?, ?, 'manual', true)", ident+"2", ident+"2", target); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("retrieves asset", func(t *testing.T) {
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, threshold), time.Hour*48)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := testCountRows(rows); got != 1 {
+			t.Errorf("want = 1, got = %v", got)
+		}
+	})
+
+	t.Run("should not retrieve asset with task in the past", func(t *testing.T) {
+		if err := ds.AddTask(Task{Pipe: "http_detect", Table: "domains", Target: target, Ident: ident}); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM pipers_last_run")
+
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(filter, threshold), time.Minute*1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := testCountRows(rows); got != 0 {
+			t.Errorf("want = 0, got = %v", got)
+		}
+
+		if due, _ := ds.Due("http_detect", "domains", target, []string{ident, "other"}, time.Minute*1); len(due) != 1 || due[0] != "other" {
+			t.Errorf("want = [other] to be due, got %v", due)
+		}
+
+		if due, _ := ds.Due("http_foobar", "domains", target, []string{ident}, time.Minute*1); len(due) != 1 {
+			t.Errorf("want asset to be due for different pipe, got %v", due)
+		}
+
+		if due, _ := ds.Due("http_detect", "services", target, []string{ident}, time.Minute*1); len(due) != 1 {
+			t.Errorf("want asset to be due for different table, got %v", due)
+		}
+
+		if due, _ := ds.Due("http_detect", "domains", "other", []string{ident}, time.Minute*1); len(due) != 1 {
+			t.Errorf("want asset to be due for different target, got %v", due)
+		}
+	})
+}
+
+func TestSqliteRetrieveInputFilter(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	target := "rv"
+
+	if _, err := db.Exec("INSERT INTO domains (id, asset, target, pipe, data) VALUES ('a', 'a', ?, 'manual', ?)", target, `{"score":100, "scope":true}`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO domains (id, asset, target, pipe, data) VALUES ('b', 'b', ?, 'manual', ?)", target, `{"score":200}`); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("retrieves asset with filter", func(t *testing.T) {
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(map[string]string{"scope": "true"}, nil), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := testCountRows(rows); got != 1 {
+			t.Errorf("want = 1, got = %v", got)
+		}
+	})
+
+	t.Run("retrieves asset with threshold", func(t *testing.T) {
+		rows, err := ds.Retrieve("domains", "http_detect", FieldsFilter(nil, map[string]string{"score": "101"}), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := testCountRows(rows); got != 1 {
+			t.Errorf("want = 1, got = %v", got)
+		}
+	})
+
+	t.Run("retrieves by target with filter", func(t *testing.T) {
+		rows, err := ds.RetrieveByTarget("domains", FieldsFilter(map[string]string{"score": "200"}, nil), target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := testCountRows(rows); got != 1 {
+			t.Errorf("want = 1, got = %v", got)
+		}
+	})
+}
+
+func TestSqliteSave(t *testing.T) {
+	ds, _ := testSqlite(t)
+
+	data := Data{Asset: "example.com", Target: "example"}
+
+	res, err := ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{"status": "200"}, SaveOptions{AssetType: "url"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Inserted {
+		t.Errorf("want first save to insert")
+	}
+
+	res, err = ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{"status": "200"}, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Inserted {
+		t.Errorf("want second save to be ignored")
+	}
+
+	rows, err := ds.RetrieveByTarget("services", FieldsFilter(map[string]string{"status": "200"}, nil), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testCountRows(rows); got != 1 {
+		t.Errorf("want = 1, got = %v", got)
+	}
+
+	filter, _ := ParseFilter("asset_type = 'url'")
+	if rows, _ := ds.RetrieveByTarget("services", filter, "example"); len(rows) != 1 || rows[0].AssetType != "url" {
+		t.Errorf("want record with asset_type url, got %+v", rows)
+	}
+
+	targets, err := ds.RetrieveTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0] != "example" {
+		t.Errorf("want target of saved record to be registered, got %v", targets)
+	}
+}
+
+func TestSqliteSaveTracked(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	data := Data{Asset: "example.com", Target: "example"}
+	opts := SaveOptions{Track: []string{"status"}}
+
+	if _, err := ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{"status": "200", "title": "a"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// untracked field changed
+	res, err := ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{"status": "200", "title": "b"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Inserted || res.Updated {
+		t.Errorf("want untracked change to be ignored, got %+v", res)
+	}
+
+	res, err = ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{"status": "404", "title": "b"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Updated || len(res.Changes) != 1 || res.Changes[0].Old != "200" || res.Changes[0].New != "404" {
+		t.Errorf("want status change, got %+v", res)
+	}
+
+	rows, _ := ds.RetrieveByTarget("services", FieldsFilter(map[string]string{"status": "404"}, nil), "example")
+	if got := testCountRows(rows); got != 1 {
+		t.Errorf("want updated record, got = %v", got)
+	}
+
+	var history string
+	if err := db.QueryRow("SELECT data FROM pipers_history WHERE tbl = 'services' AND ident = 'http://example.com'").Scan(&history); err != nil {
+		t.Fatal(err)
+	}
+	if history != `{"status":"200","title":"a"}` {
+		t.Errorf("unexpected history entry %v", history)
+	}
+}
+
+func TestSqliteMarkRemoved(t *testing.T) {
+	ds, _ := testSqlite(t)
+
+	data := Data{Asset: "example.com", Target: "example"}
+
+	if _, err := ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{}, SaveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := ds.MarkRemoved("services", "http_detect", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("want no removed records, got %v", removed)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	removed, err = ds.MarkRemoved("services", "http_detect", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Id != "http://example.com" {
+		t.Errorf("want removed record, got %v", removed)
+	}
+
+	rows, _ := ds.RetrieveByTarget("services", Filter{}, "example")
+	if got := testCountRows(rows); got != 0 {
+		t.Errorf("want removed record to be skipped, got = %v", got)
+	}
+
+	res, err := ds.Save("services", "http_detect", "http://example.com", data, map[string]interface{}{}, SaveOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Reactivated {
+		t.Errorf("want record to be reactivated, got %+v", res)
+	}
+}
+
+func TestSqliteWithoutLastSeen(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	testWithoutLastSeen(t, ds, func(query string) error {
+		_, err := db.Exec(query)
+		return err
+	})
+}
+
+func TestSqliteMigrate(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	status, err := ds.MigrationStatus(TABLES)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status) != len(TABLES)+1 {
+		t.Fatalf("want status for essentials and each table, got %+v", status)
+	}
+
+	for _, s := range status {
+		if len(s.Pending) > 0 || s.Version != s.Latest {
+			t.Errorf("want %v to be migrated, got %+v", s.Scope, s)
+		}
+	}
+
+	t.Run("migrates new tables", func(t *testing.T) {
+		if err := ds.Migrate(append(TABLES, "content")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.Exec("INSERT INTO content (id, asset, target, pipe, last_seen) VALUES ('a', 'a', 'a', 'a', '')"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("migrates tables created before versioning", func(t *testing.T) {
+		if _, err := db.Exec("CREATE TABLE legacy (id text primary key, asset text, target text, pipe text, exclude boolean, data text, created_at TIMESTAMP, active boolean default true)"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ds.Migrate([]string{"legacy"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('legacy') WHERE name = 'last_seen'").Scan(&n); err != nil || n != 1 {
+			t.Errorf("want last_seen column, got %v (%v)", n, err)
+		}
+	})
+}
+
+func TestSqlitePrune(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	if _, err := db.Exec("INSERT INTO pipers_tasks (pipe, ident, created_at) VALUES ('p', 'old', '2000-01-01 00:00:00.000')"); err != nil {
+		t.Fatal(err)
+	}
+	ds.AddTask(Task{Pipe: "p", Ident: "new"})
+
+	for _, target := range []string{"a", "b"} {
+		if _, err := db.Exec("INSERT INTO domains (id, asset, target, pipe, last_seen) VALUES (?, ?, ?, 'manual', '2000-01-01 00:00:00.000')", target, target, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policy := RetentionPolicy{
+		Tasks: "24h",
+		Data:  []DataRetention{{Table: "domains", Target: "a", MaxAge: "24h"}},
+	}
+
+	results, err := Prune(ds, policy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0].Deleted != 1 || results[1].Deleted != 1 {
+		t.Errorf("unexpected dry run results %+v", results)
+	}
+
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM pipers_tasks").Scan(&n)
+	if n != 2 {
+		t.Errorf("dry run deleted tasks")
+	}
+
+	if _, err := Prune(ds, policy, false); err != nil {
+		t.Fatal(err)
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM pipers_tasks").Scan(&n)
+	if n != 1 {
+		t.Errorf("want = 1 task, got = %v", n)
+	}
+
+	rows, _ := ds.RetrieveByTarget("domains", Filter{}, "b")
+	if got := testCountRows(rows); got != 1 {
+		t.Errorf("want record of other target to be kept, got = %v", got)
+	}
+}
+
+func TestRetentionPolicyValidate(t *testing.T) {
+	if err := (RetentionPolicy{Tasks: "720h"}).Validate(); err != nil {
+		t.Error(err)
+	}
+
+	if err := (RetentionPolicy{Alerts: "30 days"}).Validate(); err == nil {
+		t.Error("want invalid duration to fail")
+	}
+
+	if err := (RetentionPolicy{Data: []DataRetention{{MaxAge: "1h"}}}).Validate(); err == nil {
+		t.Error("want rule without table to fail")
+	}
+}
+
+func TestSqliteDueBatches(t *testing.T) {
+	ds, _ := testSqlite(t)
+
+	var idents []string
+	for i := 0; i < DUE_BATCH_SIZE*2+1; i++ {
+		idents = append(idents, fmt.Sprintf("host%v", i))
+	}
+
+	for _, ident := range idents[:DUE_BATCH_SIZE+10] {
+		ds.AddTask(Task{Pipe: "p", Table: "domains", Ident: ident})
+	}
+
+	due, err := ds.Due("p", "domains", "", idents, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(due) != DUE_BATCH_SIZE-9 || due[0] != idents[DUE_BATCH_SIZE+10] {
+		t.Errorf("unexpected due idents: %v", len(due))
+	}
+}
+
+func TestSqliteSeedLastRun(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	// task recorded before last runs existed
+	db.Exec("DELETE FROM pipers_schema_version WHERE scope = 'domains' AND version >= 3")
+	db.Exec("INSERT INTO domains (id, asset, target, pipe) VALUES ('a', 'a', 'rv', 'manual')")
+	db.Exec("INSERT INTO pipers_tasks (pipe, ident) VALUES ('http_detect', 'a')")
+
+	// as_file task keyed by a target name which is also a record id
+	db.Exec("INSERT INTO domains (id, asset, target, pipe) VALUES ('rv', 'rv', 'rv', 'manual')")
+	db.Exec("INSERT INTO pipers_tasks (pipe, ident) VALUES ('amass', 'rv')")
+
+	if err := ds.Migrate(TABLES); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := ds.Retrieve("domains", "http_detect", Filter{}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 1 || rows[0].Id != "rv" {
+		t.Errorf("want seeded last run to skip record, got %+v", rows)
+	}
+
+	var tbl string
+	if err := db.QueryRow("SELECT tbl FROM pipers_tasks WHERE ident = 'a'").Scan(&tbl); err != nil || tbl != "domains" {
+		t.Errorf("want task source to be set to domains, got %q (%v)", tbl, err)
+	}
+
+	if due, _ := ds.Due("amass", "target:domains", "rv", []string{"rv"}, time.Hour); len(due) != 0 {
+		t.Errorf("want as_file task to be seeded for its target, got %v", due)
+	}
+	if due, _ := ds.Due("amass", "domains", "rv", []string{"rv"}, time.Hour); len(due) != 1 {
+		t.Errorf("want as_file task not to be seeded for the record, got %v", due)
+	}
+	if err := db.QueryRow("SELECT tbl FROM pipers_tasks WHERE ident = 'rv'").Scan(&tbl); err != nil || tbl != "target:domains" {
+		t.Errorf("want task source to be set to target:domains, got %q (%v)", tbl, err)
+	}
+}
+
+func TestSqliteKeyByTarget(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	if _, err := db.Exec("INSERT INTO domains (id, asset, target, pipe, exclude) VALUES ('cdn.example.com', 'cdn.example.com', 'a', 'manual', true)"); err != nil {
+		t.Fatal(err)
+	}
+
+	byTarget := true
+	ds.Layout = Layout{Tables: map[string]TableOptions{"domains": {KeyByTarget: &byTarget}}}
+
+	if err := ds.Migrate(TABLES); err != nil {
+		t.Fatal(err)
+	}
+
+	// rebuilding keeps records and indexes
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM domains").Scan(&n)
+	if n != 1 {
+		t.Fatalf("want record to be kept, got %v records", n)
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = 'domains' AND name IN ('domains_asset_idx', 'domains_target_idx', 'domains_id_idx')").Scan(&n)
+	if n != 3 {
+		t.Errorf("want indexes to be recreated, got %v", n)
+	}
+
+	res, err := ds.Save("domains", "p", "cdn.example.com", Data{Target: "b"}, map[string]interface{}{"ip": "1.1.1.1"}, SaveOptions{Track: []string{"ip"}})
+	if err != nil || !res.Inserted {
+		t.Fatalf("want shared asset to be inserted for second target, got %+v, %v", res, err)
+	}
+
+	res, err = ds.Save("domains", "p", "cdn.example.com", Data{Target: "b"}, map[string]interface{}{"ip": "2.2.2.2"}, SaveOptions{Track: []string{"ip"}})
+	if err != nil || !res.Updated {
+		t.Fatalf("want record of second target to be updated, got %+v, %v", res, err)
+	}
+
+	var target string
+	db.QueryRow("SELECT target FROM pipers_history WHERE ident = 'cdn.example.com'").Scan(&target)
+	if target != "b" {
+		t.Errorf("want history of target b, got %q", target)
+	}
+
+	t.Run("exclusions apply per target", func(t *testing.T) {
+		if blocked, _ := ds.RetrieveBlocked("a"); len(blocked) != 1 {
+			t.Errorf("want asset to be blocked for a, got %v", blocked)
+		}
+
+		if blocked, _ := ds.RetrieveBlocked("b"); len(blocked) != 0 {
+			t.Errorf("want asset not to be blocked for b, got %v", blocked)
+		}
+	})
+
+	t.Run("last run applies per target", func(t *testing.T) {
+		db.Exec("UPDATE domains SET exclude = false")
+		ds.AddTask(Task{Pipe: "p", Table: "domains", Target: "a", Ident: "cdn.example.com"})
+
+		rows, err := ds.Retrieve("domains", "p", Filter{}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(rows) != 1 || rows[0].Target != "b" {
+			t.Errorf("want record of target b to be due, got %+v", rows)
+		}
+	})
+
+	t.Run("keying by id fails with shared assets", func(t *testing.T) {
+		ds.Layout = Layout{}
+		if err := ds.Migrate(TABLES); err == nil {
+			t.Errorf("want error for duplicate ids")
+		}
+	})
+}
+
+func TestSqliteTargets(t *testing.T) {
+	ds, db := testSqlite(t)
+
+	// targets of existing records are registered
+	db.Exec("DELETE FROM pipers_schema_version WHERE scope = 'domains' AND version >= 6")
+	db.Exec("INSERT INTO domains (id, asset, target, pipe) VALUES ('example.com', 'example.com', 'example', 'manual')")
+
+	if err := ds.Migrate(TABLES); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ds.AddTarget(Target{Name: "acme", Description: "bug bounty", Data: map[string]interface{}{"platform": "h1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := ds.RetrieveTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0] != "acme" || targets[1] != "example" {
+		t.Errorf("want = [acme example], got %v", targets)
+	}
+
+	if err := ds.AddTarget(Target{Name: "acme", Description: "updated"}); err != nil {
+		t.Errorf("want target to be updated, got %v", err)
+	}
+
+	t.Run("exclusions of multiple tables", func(t *testing.T) {
+		ds.Scope = Scope{ExclusionTables: []string{"domains", "seeds"}}
+
+		if err := ds.Migrate(ds.Scope.Tables(TABLES)); err != nil {
+			t.Fatal(err)
+		}
+
+		db.Exec("INSERT INTO domains (id, asset, target, pipe, exclude) VALUES ('a.example.com', 'a.example.com', 'example', 'manual', true)")
+		db.Exec("INSERT INTO seeds (id, asset, target, pipe, exclude) VALUES ('b.example.com', 'b.example.com', 'example', 'manual', true)")
+		db.Exec("INSERT INTO services (id, asset, target, pipe, exclude) VALUES ('c.example.com', 'c.example.com', 'example', 'manual', true)")
+
+		blocked, err := ds.RetrieveBlocked("example")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(blocked) != 2 {
+			t.Errorf("want exclusions of domains and seeds, got %v", blocked)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"time"
+
+	"github.com/rverton/pipers/scope"
+)
+
+// EXCLUSION_TABLE_DEFAULT holds the exclusions if none are configured
+const EXCLUSION_TABLE_DEFAULT = "domains"
+
+// Target is an entry of the target registry pipers_targets
+type Target struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Data        map[string]interface{} `json:"data"` // JSONB
+	Paused      bool                   `json:"paused"`
+	Created     time.Time              `json:"created_at"`
+}
+
+// TARGET_TABLES are the essential tables which hold rows of a target,
+// they are cleaned up when a target is removed
+var TARGET_TABLES = []string{
+	"pipers_tasks",
+	"pipers_alerts",
+	"pipers_history",
+	"pipers_last_run",
+	"pipers_watermarks",
+	"pipers_lineage",
+	"pipers_exclusions",
+}
+
+// Scope configures where exclusions are read from and the scope
+// rules of targets
+type Scope struct {
+	// excluded records of these data tables block assets
+	ExclusionTables []string `yaml:"exclusion_tables"`
+
+	// in and out of scope rules by target, "*" applies to all targets
+	Targets scope.Targets `yaml:"targets"`
+}
+
+func (s Scope) exclusionTables() []string {
+	if len(s.ExclusionTables) == 0 {
+		return []string{EXCLUSION_TABLE_DEFAULT}
+	}
+	return s.ExclusionTables
+}
+
+// Tables adds the exclusion tables to a list of data tables, so they
+// are migrated even if no pipe uses them
+func (s Scope) Tables(tables []string) []string {
+	seen := make(map[string]struct{})
+	for _, t := range tables {
+		seen[t] = struct{}{}
+	}
+
+	for _, t := range s.exclusionTables() {
+		if _, ok := seen[t]; !ok {
+			seen[t] = struct{}{}
+			tables = append(tables, t)
+		}
+	}
+
+	return tables
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func testTargetManagement(t *testing.T, ds DataService) {
+	for _, name := range []string{"example", "other"} {
+		if err := ds.AddTarget(Target{Name: name, Description: name + " inc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := &Source{Table: "domains", Ident: "example.com"}
+	for _, r := range []struct{ table, id, target string }{
+		{"domains", "example.com", "example"},
+		{"domains", "a.example.com", "example"},
+		{"services", "https://a.example.com", "example"},
+		{"domains", "other.com", "other"},
+	} {
+		if _, err := ds.Save(r.table, "p", r.id, Data{Asset: r.id, Target: r.target}, map[string]interface{}{}, SaveOptions{Source: src}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ds.SaveAlert("p", "example", "a.example.com", "new", "CREATED")
+	ds.AddTask(Task{Pipe: "p", Table: "domains", Target: "example", Ident: "example.com"})
+
+	if err := ds.PauseTarget("other", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.PauseTarget("missing", true); err == nil {
+		t.Errorf("want error for unknown target")
+	}
+
+	// updating a target keeps it paused
+	ds.AddTarget(Target{Name: "other", Description: "updated"})
+
+	targets, err := ds.ListTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0].Paused || !targets[1].Paused || targets[1].Description != "updated" || targets[0].Created.IsZero() {
+		t.Errorf("want other to be paused, got %+v", targets)
+	}
+
+	counts, err := ds.CountRecords("domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["example"] != 2 || counts["other"] != 1 {
+		t.Errorf("unexpected counts %v", counts)
+	}
+
+	deleted, err := ds.RemoveTarget("example", TABLES)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{"domains": 2, "services": 1, "pipers_alerts": 1, "pipers_tasks": 1, "pipers_lineage": 3}
+	for table, n := range want {
+		if deleted[table] != n {
+			t.Errorf("%v: want = %v deleted, got = %v", table, n, deleted[table])
+		}
+	}
+
+	if names, _ := ds.RetrieveTargets(); len(names) != 1 || names[0] != "other" {
+		t.Errorf("want only other to be left, got %v", names)
+	}
+	if counts, _ := ds.CountRecords("domains"); counts["example"] != 0 || counts["other"] != 1 {
+		t.Errorf("want records of other to be kept, got %v", counts)
+	}
+	if _, err := ds.RemoveTarget("example", TABLES); err == nil {
+		t.Errorf("want error for removed target")
+	}
+}
+
+func TestSqliteTargetManagement(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testTargetManagement(t, ds)
+}
+
+func TestMemoryTargetManagement(t *testing.T) {
+	testTargetManagement(t, &MemoryService{})
+}
+
+func testPaused(t *testing.T, ds DataService) {
+	for _, name := range []string{"example", "other"} {
+		if err := ds.AddTarget(Target{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.Save("domains", "p", name+".com", Data{Asset: name + ".com", Target: name}, map[string]interface{}{}, SaveOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ds.PausePipe("subfinder", true)
+	ds.PausePipe("amass", true)
+	ds.PausePipe("amass", false)
+	ds.PauseTarget("other", true)
+
+	paused, err := ds.RetrievePaused()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		pipe, target, want string
+	}{
+		{"subfinder", "example", "pipe paused"},
+		{"subfinder", "other", "pipe paused"},
+		{"amass", "other", "target paused"},
+		{"amass", "example", ""},
+	}
+
+	for _, tt := range tests {
+		if got := paused.Reason(tt.pipe, tt.target); got != tt.want {
+			t.Errorf("%v/%v: want = %q, got = %q", tt.pipe, tt.target, tt.want, got)
+		}
+	}
+
+	// records of paused targets are not refreshed and kept active
+	removed, err := ds.MarkRemoved("domains", "p", -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Target != "example" {
+		t.Errorf("want only the record of example to be removed, got %+v", removed)
+	}
+}
+
+func TestSqlitePaused(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testPaused(t, ds)
+}
+
+func TestMemoryPaused(t *testing.T) {
+	testPaused(t, &MemoryService{})
+}
+
+func testResumed(t *testing.T, ds DataService) {
+	for _, name := range []string{"example", "other"} {
+		if err := ds.AddTarget(Target{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.Save("domains", "p", name+".com", Data{Asset: name + ".com", Target: name}, map[string]interface{}{}, SaveOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ds.Save("domains", "q", "q.com", Data{Asset: "q.com", Target: "example"}, map[string]interface{}{}, SaveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	after := 100 * time.Millisecond
+	time.Sleep(2 * after)
+
+	ds.PauseTarget("other", true)
+	ds.PauseTarget("other", false)
+	ds.PausePipe("q", true)
+	ds.PausePipe("q", false)
+
+	// records were not refreshed while paused and are kept after a resume
+	removed, err := ds.MarkRemoved("domains", "p", after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].Id != "example.com" {
+		t.Errorf("want only example.com to be removed, got %+v", removed)
+	}
+	if removed, _ := ds.MarkRemoved("domains", "q", after); len(removed) != 0 {
+		t.Errorf("want records of resumed pipe to be kept, got %+v", removed)
+	}
+
+	// and removed once they were not seen within after of the resume
+	time.Sleep(2 * after)
+
+	if removed, _ := ds.MarkRemoved("domains", "p", after); len(removed) != 1 || removed[0].Id != "other.com" {
+		t.Errorf("want other.com to be removed, got %+v", removed)
+	}
+	if removed, _ := ds.MarkRemoved("domains", "q", after); len(removed) != 1 || removed[0].Id != "q.com" {
+		t.Errorf("want q.com to be removed, got %+v", removed)
+	}
+}
+
+func TestSqliteResumed(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testResumed(t, ds)
+}
+
+func TestMemoryResumed(t *testing.T) {
+	testResumed(t, &MemoryService{})
+}
+
+func testSaveRegistersTarget(t *testing.T, ds DataService) {
+	if err := ds.AddTarget(Target{Name: "example", Description: "Example Inc."}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []struct{ id, target string }{
+		{"a.example.com", "example"},
+		{"b.example.com", "example"},
+		{"new.com", "new"},
+	} {
+		if _, err := ds.Save("domains", "subfinder", r.id, Data{Asset: r.id, Target: r.target}, map[string]interface{}{}, SaveOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	targets, err := ds.ListTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0].Description != "Example Inc." || targets[1].Name != "new" {
+		t.Errorf("want new target to be registered and example to be kept, got %+v", targets)
+	}
+}
+
+func TestSqliteSaveRegistersTarget(t *testing.T) {
+	ds, _ := testSqlite(t)
+	testSaveRegistersTarget(t, ds)
+}
+
+func TestMemorySaveRegistersTarget(t *testing.T) {
+	testSaveRegistersTarget(t, &MemoryService{})
+}
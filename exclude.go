@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rverton/pipers/db"
+)
+
+// exclude runs the exclude command, which excludes a record and all
+// records produced from it
+func exclude(args []string, ds db.AdminService) error {
+	fs := flag.NewFlagSet("exclude", flag.ExitOnError)
+	del := fs.Bool("delete", false, "delete the records produced from the record instead of excluding them")
+	target := fs.String("target", "", "only exclude the record of this target")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: exclude [-delete] [-target name] <table> <ident>")
+	}
+
+	records, err := ds.Exclude(fs.Arg(0), *target, fs.Arg(1), db.ExcludeOptions{Delete: *del})
+	if err != nil {
+		return err
+	}
+
+	action := "excluded"
+	if *del {
+		action = "deleted"
+	}
+
+	return printRecords(records, action)
+}
+
+// include runs the include command, which reverses exclude
+func include(args []string, ds db.AdminService) error {
+	fs := flag.NewFlagSet("include", flag.ExitOnError)
+	target := fs.String("target", "", "only include the record of this target")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: include [-target name] <table> <ident>")
+	}
+
+	records, err := ds.Include(fs.Arg(0), *target, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	return printRecords(records, "included")
+}
+
+func printRecords(records []db.Record, action string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TABLE\tIDENT\tTARGET\n")
+	for _, r := range records {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", r.Table, r.Ident, r.Target)
+	}
+	fmt.Fprintf(w, "%v descendants %v\n", len(records), action)
+	return w.Flush()
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rverton/pipers/db"
+)
+
+// formats of exported rows
+const (
+	EXPORT_JSONL    = "jsonl"
+	EXPORT_CSV      = "csv"
+	EXPORT_MARKDOWN = "markdown"
+)
+
+var timeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// parseTime parses a date or a duration before now, like 24h
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, want a date or a duration", s)
+}
+
+// column is a column of exported csv and markdown rows
+type column struct {
+	name  string
+	value func(db.Row) interface{}
+}
+
+// exportColumns returns the columns of a table, data fields replace
+// the data column if set
+func exportColumns(table string, fields []string) []column {
+	var columns []column
+
+	for _, name := range db.ExportColumns(table) {
+		name := name
+		if name == "data" && len(fields) > 0 {
+			continue
+		}
+		columns = append(columns, column{name, func(r db.Row) interface{} { return r[name] }})
+	}
+
+	for _, f := range fields {
+		f := f
+		columns = append(columns, column{f, func(r db.Row) interface{} {
+			data, _ := r["data"].(map[string]interface{})
+			return data[f]
+		}})
+	}
+
+	return columns
+}
+
+// cellText renders a value of a csv or markdown cell
+func cellText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format("2006-01-02 15:04:05")
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// rowWriter writes exported rows in one of the export formats
+type rowWriter interface {
+	Write(db.Row) error
+	Flush() error
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (w jsonlWriter) Write(r db.Row) error { return w.enc.Encode(r) }
+func (w jsonlWriter) Flush() error         { return nil }
+
+// csvWriter and markdownWriter write their header before the first
+// row, or on flush if there are no rows
+type csvWriter struct {
+	w       *csv.Writer
+	columns []column
+	header  bool
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+
+	var names []string
+	for _, c := range w.columns {
+		names = append(names, c.name)
+	}
+	return w.w.Write(names)
+}
+
+func (w *csvWriter) Write(r db.Row) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	var cells []string
+	for _, c := range w.columns {
+		cells = append(cells, cellText(c.value(r)))
+	}
+	return w.w.Write(cells)
+}
+
+func (w *csvWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	w.w.Flush()
+	return w.w.Error()
+}
+
+type markdownWriter struct {
+	w       io.Writer
+	columns []column
+	header  bool
+}
+
+func (w *markdownWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+
+	var names, lines []string
+	for _, c := range w.columns {
+		names = append(names, c.name)
+		lines = append(lines, "---")
+	}
+
+	_, err := fmt.Fprintf(w.w, "| %v |\n|%v|\n", strings.Join(names, " | "), strings.Join(lines, "|"))
+	return err
+}
+
+func (w *markdownWriter) Write(r db.Row) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	var cells []string
+	for _, c := range w.columns {
+		s := strings.NewReplacer("|", "\\|", "\r", "", "\n", "<br>").Replace(cellText(c.value(r)))
+		cells = append(cells, s)
+	}
+
+	_, err := fmt.Fprintf(w.w, "| %v |\n", strings.Join(cells, " | "))
+	return err
+}
+
+func (w *markdownWriter) Flush() error { return w.writeHeader() }
+
+func newRowWriter(out io.Writer, format string, columns []column) (rowWriter, error) {
+	switch format {
+	case EXPORT_JSONL:
+		return jsonlWriter{json.NewEncoder(out)}, nil
+	case EXPORT_CSV:
+		return &csvWriter{w: csv.NewWriter(out), columns: columns}, nil
+	case EXPORT_MARKDOWN:
+		return &markdownWriter{w: out, columns: columns}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// export runs the export command, which streams the rows of a data
+// table, pipers_alerts or pipers_tasks to stdout
+func export(args []string, ds db.AdminService) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	target := fs.String("target", "", "only export rows of this target")
+	pipeName := fs.String("pipe", "", "only export rows of this pipe")
+	since := fs.String("since", "", "only export rows created since a date or a duration ago, like 24h")
+	until := fs.String("until", "", "only export rows created before a date or a duration ago")
+	where := fs.String("where", "", "filter expression on data fields, like inputs of pipes")
+	format := fs.String("format", EXPORT_JSONL, "jsonl, csv or markdown")
+	fields := fs.String("fields", "", "comma separated data fields shown as csv and markdown columns")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: export [-target name] [-pipe name] [-since t] [-until t] [-where expr] [-format f] [-fields a,b] <table|alerts|tasks>")
+	}
+
+	table := fs.Arg(0)
+	switch table {
+	case "alerts":
+		table = "pipers_alerts"
+	case "tasks":
+		table = "pipers_tasks"
+	}
+
+	q := db.ExportQuery{Target: *target, Pipe: *pipeName}
+
+	var err error
+	if q.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if q.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+	if q.Filter, err = db.ParseFilter(*where); err != nil {
+		return fmt.Errorf("invalid filter: %v", err)
+	}
+
+	var dataFields []string
+	if *fields != "" {
+		dataFields = strings.Split(*fields, ",")
+	}
+
+	w, err := newRowWriter(os.Stdout, *format, exportColumns(table, dataFields))
+	if err != nil {
+		return err
+	}
+
+	if err := ds.Export(table, q, w.Write); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rverton/pipers/asset"
+	"github.com/rverton/pipers/db"
+	"github.com/rverton/pipers/pipe"
+)
+
+// dataFlag collects key=value data fields, values are parsed as JSON
+// if possible, so scope=true is saved as boolean
+type dataFlag map[string]interface{}
+
+func (f dataFlag) String() string {
+	return fmt.Sprint(map[string]interface{}(f))
+}
+
+func (f dataFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("want key=value, got %q", s)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(parts[1]), &v); err != nil {
+		v = parts[1]
+	}
+	f[parts[0]] = v
+
+	return nil
+}
+
+// importFormat guesses the format of a file by its extension
+func importFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonl", ".json":
+		return pipe.IMPORT_JSONL
+	case ".csv":
+		return pipe.IMPORT_CSV
+	}
+	return pipe.IMPORT_TEXT
+}
+
+// importAssets runs the import command, which loads assets from a
+// text, JSONL or CSV file (- reads stdin) into a table
+func importAssets(args []string, ds db.DataService) error {
+	data := make(dataFlag)
+
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	target := fs.String("target", "", "target of the records, unless set per record")
+	format := fs.String("format", "", "text, jsonl or csv, guessed by the file extension if empty")
+	assetType := fs.String("type", string(asset.TYPE_DEFAULT), "asset_type of the records")
+	fs.Var(data, "data", "data field of every record as key=value, can be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: import [-target name] [-format f] [-type t] [-data key=value] <table> <file>")
+	}
+
+	table, filename := fs.Arg(0), fs.Arg(1)
+
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+
+		if *format == "" {
+			*format = importFormat(filename)
+		}
+	}
+
+	// the table may not be used by any pipe yet
+	if m, ok := ds.(db.Migrator); ok {
+		if err := m.Migrate([]string{table}); err != nil {
+			return err
+		}
+	}
+
+	result, err := pipe.Import(ds, r, pipe.ImportOptions{
+		Table:     table,
+		Target:    *target,
+		Format:    *format,
+		AssetType: asset.Type(*assetType),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "INSERTED\t%v\n", result.Inserted)
+	fmt.Fprintf(w, "EXISTING\t%v\n", result.Existing)
+	fmt.Fprintf(w, "SKIPPED\t%v\n", result.SkippedTotal())
+
+	var reasons []string
+	for reason := range result.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "  %v\t%v\n", reason, result.Skipped[reason])
+	}
+	return w.Flush()
+}
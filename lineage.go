@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rverton/pipers/db"
+)
+
+// lineage runs the lineage command, which prints the records a record
+// was produced from or with -descendants the records produced from it
+func lineage(args []string, ds db.AdminService) error {
+	fs := flag.NewFlagSet("lineage", flag.ExitOnError)
+	descendants := fs.Bool("descendants", false, "walk the records produced from the record")
+	target := fs.String("target", "", "only follow records of this target")
+	asJson := fs.Bool("json", false, "print edges as json lines")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: lineage [-descendants] [-target name] [-json] <table> <ident>")
+	}
+
+	edges, err := ds.Lineage(fs.Arg(0), *target, fs.Arg(1), *descendants)
+	if err != nil {
+		return err
+	}
+
+	if *asJson {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range edges {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TABLE\tIDENT\tSOURCE TABLE\tSOURCE IDENT\tTARGET\tPIPE\tTASK\tCREATED\n")
+	for _, e := range edges {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", e.Table, e.Ident, e.SourceTable, e.SourceIdent, e.Target, e.Pipe, e.TaskId, e.Created.Format("2006-01-02 15:04:05"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// as_file outputs not covered by an input record end at the whole
+	// input of their target
+	for _, e := range edges {
+		if table := strings.TrimPrefix(e.SourceTable, "target:"); table != e.SourceTable {
+			fmt.Fprintf(os.Stderr, "note: %v %v was produced by %v from all %v records of target %v\n", e.Table, e.Ident, e.Pipe, table, e.SourceIdent)
+		}
+	}
+
+	return nil
+}
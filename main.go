@@ -12,6 +12,7 @@ import (
 
 	"github.com/hibiken/asynq"
 	"github.com/joho/godotenv"
+	"github.com/rverton/pipers/config"
 	"github.com/rverton/pipers/db"
 	"github.com/rverton/pipers/notification"
 	"github.com/rverton/pipers/pipe"
@@ -39,19 +40,37 @@ func main() {
 
 	workerMode := flag.Bool("worker", false, "start in worker mode")
 	single := flag.String("single", "", "path of a single pipe to execute")
-	blacklist := flag.String("blacklist", "./resources/ips-exclude.txt", "file of IPs to exclude")
+	blacklist := flag.String("blacklist", "./resources/ips-exclude.txt", "file of IPs to exclude, more can be configured as blocklists")
 	noDb := flag.Bool("noDb", false, "do not use a database, read from stdin and print results")
 	stdin := flag.Bool("stdin", false, "read from stdin")
 	saveFailed := flag.String("saveFailed", "", "folder where failed tasks should be saved")
 	replay := flag.String("replay", "", "replay a failed task")
+	configFile := flag.String("config", "./resources/config.yml", "global config file")
 	flag.Parse()
 
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("could not load config: %v", err)
+	}
+
 	if os.Getenv("SLACK_WEBHOOK") != "" {
 		notification.SlackWebhook = os.Getenv("SLACK_WEBHOOK")
 	}
 
-	if err := pipe.LoadBlacklist(*blacklist); err != nil {
-		log.Fatalf("could not load IP blacklist: %v", err)
+	if *blacklist != "" {
+		cfg.Blocklists.Files = append([]string{*blacklist}, cfg.Blocklists.Files...)
+	}
+
+	if err := pipe.LoadBlocklists(cfg.Blocklists); err != nil {
+		log.Fatalf("could not load IP blocklists: %v", err)
+	}
+
+	if err := pipe.LoadScope(cfg.Scope.Targets); err != nil {
+		log.Fatalf("could not load scope rules: %v", err)
+	}
+
+	if err := pipe.ConfigureHosts(cfg.Hosts); err != nil {
+		log.Fatalf("could not configure host check: %v", err)
 	}
 
 	if *single == "" {
@@ -69,19 +88,79 @@ func main() {
 	}
 
 	if *noDb {
-		ds = &db.PrintService{}
+		ds = &db.PrintService{MemoryService: db.MemoryService{Layout: cfg.Layout, Scope: cfg.Scope}}
 	} else {
-		dbconn, err := db.InitDb(os.Getenv("DATABASE_URL"))
+		var closeDb func()
+
+		ds, closeDb, err = connect(os.Getenv("DATABASE_URL"), cfg)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer dbconn.Close()
+		defer closeDb()
+	}
 
-		if err := db.SetupDb(dbconn, pipe.Tables(pipes)); err != nil {
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(flag.Args()[1:], ds, cfg.Scope.Tables(pipe.Tables(pipes))); err != nil {
 			log.Fatal(err)
 		}
+		return
+	}
 
-		ds = &db.PostgresService{DB: dbconn}
+	if m, ok := ds.(db.Migrator); ok {
+		if err := m.Migrate(cfg.Scope.Tables(pipe.Tables(pipes))); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "prune":
+		if err := prune(flag.Args()[1:], ds, cfg.Retention); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "lineage":
+		if err := lineage(flag.Args()[1:], ds); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "import":
+		if err := importAssets(flag.Args()[1:], ds); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "export":
+		if err := export(flag.Args()[1:], ds); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "query":
+		if err := query(flag.Args()[1:], ds); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "target":
+		if err := target(flag.Args()[1:], ds, cfg.Scope.Tables(pipe.Tables(pipes))); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "pipe":
+		if err := pipeCommand(flag.Args()[1:], ds, pipes); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "exclude":
+		if err := exclude(flag.Args()[1:], ds); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "include":
+		if err := include(flag.Args()[1:], ds); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
 	}
 
 	ro := asynq.RedisClientOpt{Addr: "localhost:6379"}
@@ -91,13 +170,20 @@ func main() {
 	}
 
 	switch {
-	case *stdin:
+	case *stdin || *noDb:
 		log.Info("reading data from stdin")
 		if err := process(pipes, ds); err != nil {
 			log.Error(err)
 		}
+
+		if *noDb {
+			if err := processChain(pipes, ds); err != nil {
+				log.Error(err)
+			}
+		}
 	case *workerMode:
 		log.Info("starting worker")
+		pipe.WatchBlocklists()
 		startWorker(pipes, ro, ds, *saveFailed)
 	case *replay != "":
 		log.Info("replaying task")
@@ -106,14 +192,45 @@ func main() {
 		}
 	default:
 		log.Info("starting scheduler")
-		if err := scheduler(pipes, ro, ds); err != nil {
+		pipe.WatchBlocklists()
+		if err := scheduler(pipes, ro, ds, cfg); err != nil {
 			log.Error(err)
 		}
 	}
 
 }
 
-func replayTask(filename string, ds db.DataService) error {
+// connect returns the DataService selected by the scheme of the
+// database uri
+func connect(uri string, cfg config.Config) (db.DataService, func(), error) {
+	if db.IsSqlite(uri) {
+		dbconn, err := db.InitSqlite(uri)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &db.SQLiteService{
+			DB:            dbconn,
+			SkipTaskAudit: !cfg.AuditTasks(),
+			Layout:        cfg.Layout,
+			Scope:         cfg.Scope,
+		}, func() { dbconn.Close() }, nil
+	}
+
+	dbconn, err := db.InitDb(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &db.PostgresService{
+		DB:            dbconn,
+		SkipTaskAudit: !cfg.AuditTasks(),
+		Layout:        cfg.Layout,
+		Scope:         cfg.Scope,
+	}, dbconn.Close, nil
+}
+
+func replayTask(filename string, ds db.RecordService) error {
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
@@ -131,7 +248,8 @@ func replayTask(filename string, ds db.DataService) error {
 
 	return nil
 }
-func process(pipes []pipe.Pipe, ds db.DataService) error {
+
+func process(pipes []pipe.Pipe, ds db.RecordService) error {
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for scanner.Scan() {
@@ -150,7 +268,58 @@ func process(pipes []pipe.Pipe, ds db.DataService) error {
 		}
 	}
 
-	return nil
+	return scanner.Err()
+}
+
+// processChain runs all table based pipes locally until no more input
+// is due, so a whole chain of pipes can be executed without a queue.
+// Each record is processed at most once per pipe, otherwise pipes with
+// an interval shorter than the chain would never stop being due.
+func processChain(pipes []pipe.Pipe, ds db.DataService) error {
+	done := make(map[db.Task]bool)
+
+	for {
+		processed := 0
+
+		for _, p := range pipes {
+			if p.Input.File != "" || p.Input.AsFile != "" || p.Input.Table == "" {
+				continue
+			}
+
+			interval, _ := p.Interval()
+			filter, _ := p.InputFilter()
+
+			rows, err := ds.Retrieve(p.Input.Table, p.Name, filter, interval)
+			if err != nil {
+				return fmt.Errorf("could not retrieve input: %v", err)
+			}
+
+			for _, data := range rows {
+				task := db.Task{Pipe: p.Name, Table: p.Source(), Target: data.Target, Ident: data.Id}
+				if done[task] {
+					continue
+				}
+				done[task] = true
+
+				if err := ds.AddTask(task); err != nil {
+					return err
+				}
+
+				if err := pipe.Process(context.Background(), p, data, ds); err != nil {
+					log.WithFields(log.Fields{
+						"pipe": p.Name,
+					}).Errorf("pipe handle failed: %v", err)
+					return err
+				}
+
+				processed++
+			}
+		}
+
+		if processed == 0 {
+			return nil
+		}
+	}
 }
 
 // worker will use asynq and redis to listen for pipe tasks to be handled
@@ -194,9 +363,13 @@ func startWorker(pipes []pipe.Pipe, ro asynq.RedisClientOpt, ds db.DataService,
 }
 
 // scheduler will load all pipes and add tasks to a queue
-func scheduler(pipes []pipe.Pipe, ro asynq.RedisClientOpt, ds db.DataService) error {
+func scheduler(pipes []pipe.Pipe, ro asynq.RedisClientOpt, ds db.DataService, cfg config.Config) error {
 	redisClient := asynq.NewClient(ro)
 
+	if cfg.Retention.Enabled() {
+		go pruneLoop(ds, cfg.Retention)
+	}
+
 	var wg sync.WaitGroup
 	for _, p := range pipes {
 		wg.Add(1)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rverton/pipers/db"
+)
+
+// migrate runs the migrate command, either applying all pending
+// migrations (up) or printing the schema version of each table (status)
+func migrate(args []string, ds db.DataService, tables []string) error {
+	m, ok := ds.(db.Migrator)
+	if !ok {
+		return fmt.Errorf("data service does not support migrations")
+	}
+
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		return m.Migrate(tables)
+	case "status":
+		status, err := m.MigrationStatus(tables)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SCOPE\tVERSION\tLATEST\tPENDING")
+		for _, s := range status {
+			var pending []string
+			for _, p := range s.Pending {
+				pending = append(pending, fmt.Sprintf("%v %v", p.Version, p.Name))
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", s.Scope, s.Version, s.Latest, strings.Join(pending, ", "))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate action %q, use up or status", action)
+	}
+}
@@ -0,0 +1,287 @@
+package pipe
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BLOCKLIST_POLL is how often blocklist files are checked for changes
+var BLOCKLIST_POLL = 30 * time.Second
+
+// Blocklists are files of IPs and networks, assets resolving to one of
+// them are skipped. Files of a target only apply to its assets.
+type Blocklists struct {
+	Files   []string
+	Targets map[string][]string
+}
+
+// files returns all files of the global and target lists
+func (b Blocklists) files() []string {
+	seen := make(map[string]struct{})
+	var files []string
+
+	add := func(fs []string) {
+		for _, f := range fs {
+			if _, ok := seen[f]; !ok {
+				seen[f] = struct{}{}
+				files = append(files, f)
+			}
+		}
+	}
+
+	add(b.Files)
+	for _, fs := range b.Targets {
+		add(fs)
+	}
+
+	return files
+}
+
+type blocklistFile struct {
+	networks []*net.IPNet
+	modTime  time.Time
+}
+
+type blocklistState struct {
+	mu     sync.RWMutex
+	config Blocklists
+	files  map[string]blocklistFile
+}
+
+var blocklists = &blocklistState{files: make(map[string]blocklistFile)}
+
+// LoadBlocklists reads all blocklist files. A missing file is an error,
+// malformed lines are logged and skipped.
+func LoadBlocklists(b Blocklists) error {
+	files := make(map[string]blocklistFile)
+
+	for _, path := range b.files() {
+		f, err := readBlocklist(path)
+		if err != nil {
+			return err
+		}
+		files[path] = f
+	}
+
+	blocklists.mu.Lock()
+	blocklists.config = b
+	blocklists.files = files
+	blocklists.mu.Unlock()
+
+	for path, f := range files {
+		log.WithFields(log.Fields{
+			"file":     path,
+			"networks": len(f.networks),
+		}).Info("blocklist loaded")
+	}
+
+	return nil
+}
+
+// ReloadBlocklists reads changed blocklist files again, or all of them
+// if force is set. A file which can not be read keeps its previous
+// networks.
+func ReloadBlocklists(force bool) {
+	blocklists.mu.RLock()
+	config := blocklists.config
+	current := blocklists.files
+	blocklists.mu.RUnlock()
+
+	files := make(map[string]blocklistFile)
+	changed := false
+
+	for _, path := range config.files() {
+		old := current[path]
+
+		if !force {
+			if info, err := os.Stat(path); err == nil && info.ModTime().Equal(old.modTime) {
+				files[path] = old
+				continue
+			}
+		}
+
+		f, err := readBlocklist(path)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file":  path,
+				"error": err,
+			}).Error("reloading blocklist failed, keeping previous version")
+			files[path] = old
+			continue
+		}
+
+		added, removed := diffNetworks(old.networks, f.networks)
+		if len(added) > 0 || len(removed) > 0 {
+			logger := log.WithField("file", path)
+			logger.WithFields(log.Fields{
+				"networks": len(f.networks),
+				"added":    len(added),
+				"removed":  len(removed),
+			}).Info("blocklist changed")
+			logger.WithFields(log.Fields{
+				"added":   strings.Join(added, ", "),
+				"removed": strings.Join(removed, ", "),
+			}).Debug("blocklist changes")
+			changed = true
+		}
+
+		files[path] = f
+	}
+
+	blocklists.mu.Lock()
+	blocklists.files = files
+	blocklists.mu.Unlock()
+
+	if force && !changed {
+		log.Info("blocklists reloaded, nothing changed")
+	}
+}
+
+// WatchBlocklists reloads blocklists on SIGHUP or when a file changed
+func WatchBlocklists() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(BLOCKLIST_POLL)
+
+	go func() {
+		for {
+			select {
+			case <-hup:
+				ReloadBlocklists(true)
+			case <-ticker.C:
+				ReloadBlocklists(false)
+			}
+		}
+	}()
+}
+
+// readBlocklist parses a file of networks and single IPs, # starts a
+// comment
+func readBlocklist(path string) (blocklistFile, error) {
+	var f blocklistFile
+
+	file, err := os.Open(path)
+	if err != nil {
+		return f, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return f, err
+	}
+	f.modTime = info.ModTime()
+
+	n := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n++
+
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		network, err := parseNetwork(line)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"file":  path,
+				"line":  n,
+				"error": err,
+			}).Warn("skipping malformed blocklist entry")
+			continue
+		}
+
+		f.networks = append(f.networks, network)
+	}
+
+	return f, scanner.Err()
+}
+
+// parseNetwork parses a CIDR or a single IP
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", s)
+		}
+
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(s)
+	return network, err
+}
+
+func diffNetworks(old, new []*net.IPNet) ([]string, []string) {
+	set := func(networks []*net.IPNet) map[string]struct{} {
+		m := make(map[string]struct{})
+		for _, n := range networks {
+			m[n.String()] = struct{}{}
+		}
+		return m
+	}
+
+	before, after := set(old), set(new)
+
+	var added, removed []string
+	for n := range after {
+		if _, ok := before[n]; !ok {
+			added = append(added, n)
+		}
+	}
+	for n := range before {
+		if _, ok := after[n]; !ok {
+			removed = append(removed, n)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// isBlockedIp checks an IP against the global blocklists and the
+// blocklists of a target
+func isBlockedIp(target string, ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return true
+	}
+
+	blocklists.mu.RLock()
+	defer blocklists.mu.RUnlock()
+
+	files := append([]string{}, blocklists.config.Files...)
+	files = append(files, blocklists.config.Targets[target]...)
+
+	for _, path := range files {
+		for _, network := range blocklists.files[path].networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
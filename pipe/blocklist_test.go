@@ -0,0 +1,68 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlocklists(t *testing.T) {
+	dir := t.TempDir()
+	global := filepath.Join(dir, "global.txt")
+	example := filepath.Join(dir, "example.txt")
+
+	ioutil.WriteFile(global, []byte("# private networks\n\n10.0.0.0/8\n192.0.2.1 # single ip\nnot an ip\n2001:db8::/32\n"), 0644)
+	ioutil.WriteFile(example, []byte("198.51.100.7\n"), 0644)
+
+	err := LoadBlocklists(Blocklists{
+		Files:   []string{global},
+		Targets: map[string][]string{"example": {example}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer LoadBlocklists(Blocklists{})
+
+	tests := []struct {
+		target string
+		ip     string
+		want   bool
+	}{
+		{"", "10.1.2.3", true},
+		{"", "192.0.2.1", true},
+		{"", "192.0.2.2", false},
+		{"", "2001:db8::1", true},
+		{"", "::1", true},
+		{"", "198.51.100.7", false},
+		{"example", "198.51.100.7", true},
+		{"example", "10.1.2.3", true},
+	}
+
+	for _, tt := range tests {
+		if got := isBlockedIp(tt.target, net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%v %v: want = %v, got = %v", tt.target, tt.ip, tt.want, got)
+		}
+	}
+
+	// changed files are reloaded
+	ioutil.WriteFile(global, []byte("192.0.2.2\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(global, later, later)
+
+	ReloadBlocklists(false)
+
+	if isBlockedIp("", net.ParseIP("10.1.2.3")) || !isBlockedIp("", net.ParseIP("192.0.2.2")) {
+		t.Errorf("want changed blocklist to be reloaded")
+	}
+
+	// a removed file keeps its networks
+	os.Remove(example)
+	ReloadBlocklists(true)
+
+	if !isBlockedIp("example", net.ParseIP("198.51.100.7")) {
+		t.Errorf("want unreadable blocklist to keep its networks")
+	}
+}
@@ -0,0 +1,220 @@
+package pipe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rverton/pipers/asset"
+	"github.com/rverton/pipers/scope"
+	log "github.com/sirupsen/logrus"
+)
+
+const HOST_LOOKUP_TIMEOUT = 3 * time.Second
+const HOST_CACHE_TTL_DEFAULT = "5m"
+
+// HOST_CACHE_SIZE is the number of cached hosts after which expired
+// entries are dropped
+const HOST_CACHE_SIZE = 10000
+
+// failure policies of HostCheck
+const (
+	ON_FAILURE_ALLOW = "allow"
+	ON_FAILURE_SKIP  = "skip"
+)
+
+// HostCheck configures how assets are resolved before a pipe processes
+// them. Assets resolving to a blocked address are skipped.
+type HostCheck struct {
+	// DNS server as host:port, the system resolver is used if empty
+	Resolver string
+
+	// allow or skip assets which can not be resolved, defaults to allow
+	OnFailure string `yaml:"on_failure"`
+
+	// how long resolutions are cached, time.Duration format
+	CacheTTL string `yaml:"cache_ttl"`
+}
+
+func (h HostCheck) ttl() (time.Duration, error) {
+	if h.CacheTTL == "" {
+		h.CacheTTL = HOST_CACHE_TTL_DEFAULT
+	}
+	return time.ParseDuration(h.CacheTTL)
+}
+
+// Validate checks the failure policy, resolver and cache ttl
+func (h HostCheck) Validate() error {
+	switch h.OnFailure {
+	case "", ON_FAILURE_ALLOW, ON_FAILURE_SKIP:
+	default:
+		return fmt.Errorf("invalid on_failure %q, use %v or %v", h.OnFailure, ON_FAILURE_ALLOW, ON_FAILURE_SKIP)
+	}
+
+	if h.Resolver != "" {
+		if _, _, err := net.SplitHostPort(h.Resolver); err != nil {
+			return fmt.Errorf("invalid resolver %q: %v", h.Resolver, err)
+		}
+	}
+
+	if ttl, err := h.ttl(); err != nil || ttl < 0 {
+		return fmt.Errorf("invalid cache_ttl %q", h.CacheTTL)
+	}
+
+	return nil
+}
+
+type hostEntry struct {
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// hostChecker resolves hosts and caches the results, it is shared by
+// all workers of a process
+type hostChecker struct {
+	config HostCheck
+	ttl    time.Duration
+	lookup func(ctx context.Context, host string) ([]net.IP, error)
+
+	mu    sync.Mutex
+	cache map[string]hostEntry
+}
+
+var hosts = newHostChecker(HostCheck{})
+
+func newHostChecker(h HostCheck) *hostChecker {
+	ttl, _ := h.ttl()
+
+	resolver := net.DefaultResolver
+	if h.Resolver != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, h.Resolver)
+			},
+		}
+	}
+
+	return &hostChecker{
+		config: h,
+		ttl:    ttl,
+		cache:  make(map[string]hostEntry),
+		lookup: func(ctx context.Context, host string) ([]net.IP, error) {
+			// both A and AAAA records
+			return resolver.LookupIP(ctx, "ip", host)
+		},
+	}
+}
+
+// ConfigureHosts replaces the resolver, failure policy and cache of
+// CheckHost
+func ConfigureHosts(h HostCheck) error {
+	if err := h.Validate(); err != nil {
+		return err
+	}
+
+	hosts = newHostChecker(h)
+
+	log.WithFields(log.Fields{
+		"resolver":   h.Resolver,
+		"on_failure": h.OnFailure,
+		"cache_ttl":  hosts.ttl,
+	}).Debug("host check configured")
+
+	return nil
+}
+
+// HostDecision is the result of a host check
+type HostDecision struct {
+	Valid  bool
+	Reason string // why the asset is skipped
+}
+
+// CheckHost checks that the host of an asset does not resolve to an
+// address of the blocklists of its target. All addresses are checked,
+// if the asset is skipped the reason is returned. Empty assets and
+// asset types without a host, like email or cidr, are not checked.
+func CheckHost(target string, t asset.Type, value string) HostDecision {
+	return hosts.check(target, t, value)
+}
+
+func (c *hostChecker) check(target string, t asset.Type, value string) HostDecision {
+	if value == "" || !t.HasHost() {
+		return HostDecision{Valid: true}
+	}
+
+	host := scope.ParseAsset(value).Host()
+	if host == "" {
+		return c.failed(fmt.Errorf("no host"))
+	}
+
+	// ip assets are not resolved
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedIp(target, ip) {
+			return HostDecision{Reason: fmt.Sprintf("blocked address %v", ip)}
+		}
+		return HostDecision{Valid: true}
+	}
+
+	ips, err := c.resolve(host)
+	if err != nil {
+		return c.failed(err)
+	}
+
+	if len(ips) == 0 {
+		return c.failed(fmt.Errorf("no addresses"))
+	}
+
+	for _, ip := range ips {
+		if isBlockedIp(target, ip) {
+			return HostDecision{Reason: fmt.Sprintf("resolves to blocked address %v", ip)}
+		}
+	}
+
+	return HostDecision{Valid: true}
+}
+
+// failed applies the failure policy to a failed resolution
+func (c *hostChecker) failed(err error) HostDecision {
+	if c.config.OnFailure == ON_FAILURE_SKIP {
+		return HostDecision{Reason: fmt.Sprintf("resolution failed: %v", err)}
+	}
+	return HostDecision{Valid: true}
+}
+
+func (c *hostChecker) resolve(host string) ([]net.IP, error) {
+	c.mu.Lock()
+	entry, ok := c.cache[host]
+	c.mu.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, entry.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HOST_LOOKUP_TIMEOUT)
+	defer cancel()
+
+	ips, err := c.lookup(ctx, host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl > 0 {
+		c.cache[host] = hostEntry{ips: ips, err: err, expires: time.Now().Add(c.ttl)}
+	}
+
+	if len(c.cache) > HOST_CACHE_SIZE {
+		now := time.Now()
+		for k, e := range c.cache {
+			if now.After(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+	}
+
+	return ips, err
+}
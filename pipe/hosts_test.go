@@ -0,0 +1,109 @@
+package pipe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/rverton/pipers/asset"
+)
+
+func testHostChecker(t *testing.T, h HostCheck) (*hostChecker, *int) {
+	if err := LoadBlocklists(Blocklists{Files: []string{"../resources/ips-exclude.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string][]string{
+		"public.example.com": {"93.184.216.34", "2606:2800:220:1::1"},
+		"mixed.example.com":  {"93.184.216.34", "10.0.0.1"},
+		"v6.example.com":     {"fd00::1"},
+		"empty.example.com":  {},
+	}
+
+	lookups := 0
+	c := newHostChecker(h)
+	c.lookup = func(ctx context.Context, host string) ([]net.IP, error) {
+		lookups++
+		addrs, ok := records[host]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+
+		var ips []net.IP
+		for _, a := range addrs {
+			ips = append(ips, net.ParseIP(a))
+		}
+		return ips, nil
+	}
+
+	return c, &lookups
+}
+
+func TestCheckHost(t *testing.T) {
+	c, lookups := testHostChecker(t, HostCheck{})
+
+	tests := []struct {
+		asset string
+		want  bool
+	}{
+		{"public.example.com", true},
+		{"https://mixed.example.com/login", false},
+		{"v6.example.com:443", false},
+		{"missing.example.com", true},
+		{"empty.example.com", true},
+		{"127.0.0.1", false},
+		{"http://[::1]:8080/", false},
+		{"93.184.216.34", true},
+	}
+
+	for _, tt := range tests {
+		if d := c.check("", "", tt.asset); d.Valid != tt.want {
+			t.Errorf("%q: want = %v, got = %+v", tt.asset, tt.want, d)
+		}
+	}
+
+	// resolutions are cached
+	before := *lookups
+	c.check("", asset.DOMAIN, "public.example.com")
+	if *lookups != before {
+		t.Errorf("want cached resolution, got %v lookups", *lookups-before)
+	}
+}
+
+func TestCheckHostFailurePolicy(t *testing.T) {
+	c, _ := testHostChecker(t, HostCheck{OnFailure: ON_FAILURE_SKIP, CacheTTL: "0s"})
+
+	for _, value := range []string{"missing.example.com", "empty.example.com"} {
+		if d := c.check("", asset.DOMAIN, value); d.Valid || d.Reason == "" {
+			t.Errorf("%q: want to be skipped with a reason, got %+v", value, d)
+		}
+	}
+
+	if d := c.check("", asset.DOMAIN, "public.example.com"); !d.Valid {
+		t.Errorf("want public host to be valid")
+	}
+
+	// only assets with a host are resolved
+	tests := []struct {
+		t     asset.Type
+		value string
+	}{
+		{asset.DOMAIN, ""},
+		{asset.EMAIL, "admin@missing.example.com"},
+		{asset.CIDR, "10.0.0.0/8"},
+		{asset.TEXT, "missing.example.com"},
+	}
+
+	for _, tt := range tests {
+		if d := c.check("", tt.t, tt.value); !d.Valid {
+			t.Errorf("%v %q: want not to be checked, got %+v", tt.t, tt.value, d)
+		}
+	}
+
+	for _, h := range []HostCheck{{OnFailure: "deny"}, {Resolver: "1.1.1.1"}, {CacheTTL: "soon"}} {
+		if err := h.Validate(); err == nil {
+			t.Errorf("%+v: want error", h)
+		}
+	}
+}
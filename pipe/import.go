@@ -0,0 +1,304 @@
+package pipe
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/rverton/pipers/asset"
+	"github.com/rverton/pipers/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// formats of imported files
+const (
+	IMPORT_TEXT  = "text"  // one asset per line, # starts a comment
+	IMPORT_JSONL = "jsonl" // one object per line
+	IMPORT_CSV   = "csv"   // a header row followed by records
+)
+
+// IMPORT_PIPE is the pipe name imported records are saved with
+const IMPORT_PIPE = "import"
+
+// ImportOptions configure how records are imported. Objects of JSONL
+// and CSV files have an asset field, the optional fields ident and
+// target override the defaults, all other fields are saved as data.
+type ImportOptions struct {
+	Table     string
+	Target    string // default target of records
+	Format    string
+	AssetType asset.Type
+	Data      map[string]interface{} // added to the data of every record
+}
+
+// ImportResult counts the imported records, skipped records are
+// counted by reason
+type ImportResult struct {
+	Inserted int
+	Existing int // saved before, their data is not changed
+	Skipped  map[string]int
+}
+
+func (r *ImportResult) skip(reason string) {
+	if r.Skipped == nil {
+		r.Skipped = make(map[string]int)
+	}
+	r.Skipped[reason]++
+}
+
+// SkippedTotal returns the number of all skipped records
+func (r ImportResult) SkippedTotal() int {
+	var n int
+	for _, c := range r.Skipped {
+		n += c
+	}
+	return n
+}
+
+// importRecord is a parsed line of an imported file
+type importRecord struct {
+	line   int
+	ident  string
+	asset  string
+	target string
+	data   map[string]interface{}
+}
+
+// Import reads records from r and saves them into a table. Assets are
+// validated, normalized and checked against the scope like outputs of
+// Process. Records are deduplicated on their ident.
+func Import(ds db.DataService, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	var result ImportResult
+
+	if opts.AssetType == "" {
+		opts.AssetType = asset.TYPE_DEFAULT
+	}
+
+	if opts.Table == "" {
+		return result, fmt.Errorf("no table to import into")
+	}
+
+	if !opts.AssetType.Valid() {
+		return result, fmt.Errorf("invalid asset_type %q", opts.AssetType)
+	}
+
+	records, err := parseImport(r, opts.Format)
+	if err != nil {
+		return result, err
+	}
+
+	targets, err := ds.RetrieveTargets()
+	if err != nil {
+		return result, fmt.Errorf("cant retrieve targets: %v", err)
+	}
+
+	registered := make(map[string]bool)
+	for _, t := range targets {
+		registered[t] = true
+	}
+
+	exclusions := NewExclusions(ds)
+	seen := make(map[string]bool)
+
+	for _, rec := range records {
+		logger := log.WithFields(log.Fields{"line": rec.line, "asset": rec.asset})
+
+		target := opts.Target
+		if rec.target != "" {
+			target = rec.target
+		}
+
+		if target == "" {
+			logger.Info("no target, skipping")
+			result.skip("no target")
+			continue
+		}
+
+		normalized, err := asset.Normalize(opts.AssetType, rec.asset)
+		if err != nil {
+			logger.WithField("error", err).Info("invalid asset, skipping")
+			result.skip("invalid")
+			continue
+		}
+
+		ident := normalized
+		if rec.ident != "" {
+			ident = rec.ident
+		}
+
+		key := target + "\x00" + ident
+		if seen[key] {
+			result.skip("duplicate")
+			continue
+		}
+		seen[key] = true
+
+		d, err := exclusions.Check(target, normalized)
+		if err != nil {
+			return result, fmt.Errorf("cant retrieve blocklist: %v", err)
+		}
+
+		if !d.InScope {
+			logger.WithFields(log.Fields{
+				"rule":   d.Rule,
+				"reason": d.Reason,
+			}).Info("asset not in scope, skipping")
+			result.skip("out of scope")
+			continue
+		}
+
+		if !registered[target] {
+			if err := ds.AddTarget(db.Target{Name: target}); err != nil {
+				return result, fmt.Errorf("cant register target %v: %v", target, err)
+			}
+			registered[target] = true
+		}
+
+		data := make(map[string]interface{})
+		for k, v := range opts.Data {
+			data[k] = v
+		}
+		for k, v := range rec.data {
+			data[k] = v
+		}
+		data["asset"] = normalized
+
+		res, err := ds.Save(opts.Table, IMPORT_PIPE, ident, db.Data{Asset: normalized, Target: target}, data, db.SaveOptions{
+			AssetType: string(opts.AssetType),
+		})
+		if err != nil {
+			return result, fmt.Errorf("unable to save %v: %v", ident, err)
+		}
+
+		if res.Inserted {
+			result.Inserted++
+		} else {
+			result.Existing++
+		}
+	}
+
+	return result, nil
+}
+
+func parseImport(r io.Reader, format string) ([]importRecord, error) {
+	switch format {
+	case "", IMPORT_TEXT:
+		return parseImportText(r)
+	case IMPORT_JSONL:
+		return parseImportJsonl(r)
+	case IMPORT_CSV:
+		return parseImportCsv(r)
+	}
+
+	return nil, fmt.Errorf("unknown import format %q", format)
+}
+
+func parseImportText(r io.Reader) ([]importRecord, error) {
+	var records []importRecord
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		s := scanner.Text()
+		if i := strings.Index(s, "#"); i >= 0 {
+			s = s[:i]
+		}
+
+		if s = strings.TrimSpace(s); s != "" {
+			records = append(records, importRecord{line: line, asset: s})
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+func parseImportJsonl(r io.Reader) ([]importRecord, error) {
+	var records []importRecord
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+
+		rec, err := newImportRecord(line, fields)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
+
+func parseImportCsv(r io.Reader) ([]importRecord, error) {
+	var records []importRecord
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cant read csv header: %v", err)
+	}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		fields := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			if i < len(row) {
+				fields[name] = row[i]
+			}
+		}
+
+		rec, err := newImportRecord(line, fields)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// newImportRecord splits the fields of an object into the record
+// columns and its data
+func newImportRecord(line int, fields map[string]interface{}) (importRecord, error) {
+	rec := importRecord{line: line, data: make(map[string]interface{})}
+
+	for k, v := range fields {
+		var column *string
+		switch k {
+		case "asset":
+			column = &rec.asset
+		case "ident":
+			column = &rec.ident
+		case "target":
+			column = &rec.target
+		default:
+			rec.data[k] = v
+			continue
+		}
+
+		s, ok := v.(string)
+		if !ok {
+			return rec, fmt.Errorf("line %v: %v is not a string", line, k)
+		}
+		*column = s
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,89 @@
+package pipe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rverton/pipers/db"
+)
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+	}{
+		{IMPORT_TEXT, "# scope\nExample.com.\nexample.com\n\nfoo.example.com # dev\nnot a domain\nexcluded.com\n"},
+		{IMPORT_JSONL, `{"asset":"Example.com."}
+{"asset":"example.com"}
+{"asset":"foo.example.com","note":"dev"}
+{"asset":"not a domain"}
+{"asset":"excluded.com"}
+`},
+		{IMPORT_CSV, "asset,note\nExample.com.,\nexample.com,\nfoo.example.com,dev\nnot a domain,\nexcluded.com,\n"},
+	}
+
+	for _, tt := range tests {
+		ds := &db.MemoryService{}
+		ds.Insert("domains", db.Data{Id: "excluded.com", Asset: "excluded.com", Target: "example"}, true)
+
+		res, err := Import(ds, strings.NewReader(tt.input), ImportOptions{
+			Table:  "domains",
+			Target: "example",
+			Format: tt.format,
+			Data:   map[string]interface{}{"scope": true},
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", tt.format, err)
+		}
+
+		want := ImportResult{Inserted: 2, Skipped: map[string]int{"duplicate": 1, "invalid": 1, "out of scope": 1}}
+		if !reflect.DeepEqual(res, want) {
+			t.Errorf("%v: want = %+v, got = %+v", tt.format, want, res)
+		}
+
+		var assets []string
+		for _, r := range ds.Records("domains") {
+			if r.Id != "excluded.com" && (r.Data["scope"] != true || r.AssetType != "domain") {
+				t.Errorf("%v: unexpected record %+v", tt.format, r)
+			}
+			assets = append(assets, r.Asset)
+		}
+
+		if got := strings.Join(assets, ","); got != "excluded.com,example.com,foo.example.com" {
+			t.Errorf("%v: unexpected assets %v", tt.format, got)
+		}
+	}
+}
+
+func TestImportTargets(t *testing.T) {
+	ds := &db.MemoryService{}
+
+	input := `{"asset":"a.example.com","target":"a"}
+{"asset":"b.example.com","ident":"b"}
+{"asset":"c.example.com"}
+`
+	for i := 0; i < 2; i++ {
+		res, err := Import(ds, strings.NewReader(input), ImportOptions{Table: "domains", Format: IMPORT_JSONL})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Skipped["no target"] != 2 {
+			t.Errorf("want = 2 records without target, got = %+v", res)
+		}
+
+		if i == 1 && (res.Inserted != 0 || res.Existing != 1) {
+			t.Errorf("want existing record on second import, got = %+v", res)
+		}
+	}
+
+	targets, _ := ds.RetrieveTargets()
+	if !reflect.DeepEqual(targets, []string{"a"}) {
+		t.Errorf("want target a to be registered, got = %v", targets)
+	}
+
+	if _, err := Import(ds, strings.NewReader("{}\n"), ImportOptions{Table: "domains", Format: "xml"}); err == nil {
+		t.Errorf("want error for unknown format")
+	}
+}
@@ -0,0 +1,227 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// types of output data fields
+const (
+	FIELD_STRING = "string"
+	FIELD_INT    = "int"
+	FIELD_FLOAT  = "float"
+	FIELD_BOOL   = "bool"
+	FIELD_JSON   = "json" // the rendered value is decoded as JSON
+)
+
+var FIELD_TYPES = []string{FIELD_STRING, FIELD_INT, FIELD_FLOAT, FIELD_BOOL, FIELD_JSON}
+
+// OutputData is the data block of an output. Strings are templates,
+// maps and lists are rendered recursively and other YAML values like
+// numbers and booleans are saved as they are.
+type OutputData map[string]interface{}
+
+func (d *OutputData) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+
+	v, err := yamlValue(m)
+	if err != nil {
+		return err
+	}
+
+	*d = v.(map[string]interface{})
+	return nil
+}
+
+// yamlValue converts the nested maps decoded by yaml to maps with
+// string keys, as they are encoded to JSON
+func yamlValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid data key %v", k)
+			}
+
+			c, err := yamlValue(val)
+			if err != nil {
+				return nil, err
+			}
+			m[s] = c
+		}
+		return m, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			c, err := yamlValue(val)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = c
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, val := range v {
+			c, err := yamlValue(val)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = c
+		}
+		return l, nil
+	}
+
+	return v, nil
+}
+
+// paths returns the paths of all templates and values of the data,
+// keys of nested maps are joined by dots, lists share their path
+func (d OutputData) paths() map[string]bool {
+	paths := make(map[string]bool)
+
+	var walk func(path string, v interface{})
+	walk = func(path string, v interface{}) {
+		paths[path] = true
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, val := range v {
+				walk(path+"."+k, val)
+			}
+		case []interface{}:
+			for _, val := range v {
+				walk(path, val)
+			}
+		}
+	}
+
+	for k, v := range d {
+		walk(k, v)
+	}
+
+	return paths
+}
+
+// validateTypes checks the type annotations of output data fields
+func validateTypes(data OutputData, types map[string]string) error {
+	paths := data.paths()
+
+	var keys []string
+	for k := range types {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, path := range keys {
+		if !validFieldType(types[path]) {
+			return fmt.Errorf("invalid type %q of %v", types[path], path)
+		}
+
+		if !paths[path] {
+			return fmt.Errorf("type of unknown field %v", path)
+		}
+	}
+
+	return nil
+}
+
+func validFieldType(t string) bool {
+	for _, v := range FIELD_TYPES {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// renderField renders the templates of a field and converts them
+// according to the type annotation of their path
+func renderField(path string, v interface{}, types map[string]string, tplData map[string]interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		s, err := Tpl(v, tplData)
+		if err != nil {
+			return nil, err
+		}
+		return convertField(types[path], s)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			r, err := renderField(path+"."+k, val, types, tplData)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = r
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, val := range v {
+			r, err := renderField(path, val, types, tplData)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = r
+		}
+		return l, nil
+	}
+
+	return v, nil
+}
+
+// convertField converts a rendered template to a type, empty values
+// of types other than string are saved as null
+func convertField(t, s string) (interface{}, error) {
+	if t == "" || t == FIELD_STRING {
+		return s, nil
+	}
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	switch t {
+	case FIELD_INT:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n, nil
+		}
+
+		// numbers of JSON outputs are rendered like 1e+06
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || f != math.Trunc(f) {
+			return nil, fmt.Errorf("invalid int %q", s)
+		}
+		return int64(f), nil
+	case FIELD_FLOAT:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", s)
+		}
+		return f, nil
+	case FIELD_BOOL:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", s)
+		}
+		return b, nil
+	case FIELD_JSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, fmt.Errorf("invalid json: %v", err)
+		}
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("unknown type %q", t)
+}
@@ -0,0 +1,111 @@
+package pipe
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/rverton/pipers/db"
+	"gopkg.in/yaml.v2"
+)
+
+const typedPipe = `
+name: typed
+cmd: echo '{"host":"a.example.com","port":443,"tls":true,"tags":["a","b"],"server":"nginx"}'
+output:
+  table: services
+  ident: ${.outputJson.host}
+  asset: ${.outputJson.host}
+  data:
+    port: ${.outputJson.port}
+    tls: ${.outputJson.tls}
+    tags: ${.outputJson.tags | toJson}
+    service: http
+    weight: 1.5
+    headers:
+      server: ${.outputJson.server}
+      ports:
+        - ${.outputJson.port}
+        - 8443
+  types:
+    port: int
+    tls: bool
+    tags: json
+    headers.ports: int
+`
+
+func TestOutputTypes(t *testing.T) {
+	var p Pipe
+	if err := yaml.Unmarshal([]byte(typedPipe), &p); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := &db.MemoryService{}
+	data := db.Data{Asset: "example.com", Target: "example", Data: map[string]interface{}{}}
+
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	records := ds.Records("services")
+	if len(records) != 1 {
+		t.Fatalf("want = 1 record, got = %v", len(records))
+	}
+
+	want := map[string]interface{}{
+		"port":    int64(443),
+		"tls":     true,
+		"tags":    []interface{}{"a", "b"},
+		"service": "http",
+		"weight":  1.5,
+		"headers": map[string]interface{}{
+			"server": "nginx",
+			"ports":  []interface{}{int64(443), 8443},
+		},
+	}
+	if got := records[0].Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("want = %#v, got = %#v", want, got)
+	}
+
+	// numeric thresholds match typed fields
+	rows, err := ds.Retrieve("services", "other", db.FieldsFilter(nil, map[string]string{"port": "400"}), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 {
+		t.Errorf("want = 1 record above threshold, got = %v", len(rows))
+	}
+}
+
+func TestOutputTypesInvalid(t *testing.T) {
+	data := OutputData{"port": "${.output}", "nested": map[string]interface{}{"a": "${.output}"}}
+
+	tests := []struct {
+		types map[string]string
+		ok    bool
+	}{
+		{map[string]string{"port": "int", "nested.a": "float"}, true},
+		{map[string]string{"port": "number"}, false},
+		{map[string]string{"missing": "int"}, false},
+		{map[string]string{"nested.b": "int"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := validateTypes(data, tt.types); (err == nil) != tt.ok {
+			t.Errorf("%v: want ok = %v, got = %v", tt.types, tt.ok, err)
+		}
+	}
+
+	for _, tt := range []struct{ typ, s string }{{FIELD_INT, "1.5"}, {FIELD_BOOL, "yes"}, {FIELD_JSON, "{"}} {
+		if _, err := convertField(tt.typ, tt.s); err == nil {
+			t.Errorf("%v %q: want error", tt.typ, tt.s)
+		}
+	}
+
+	if v, err := convertField(FIELD_INT, "1e+06"); err != nil || v != int64(1000000) {
+		t.Errorf("want = 1000000, got = %v (%v)", v, err)
+	}
+}
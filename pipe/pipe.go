@@ -10,14 +10,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
 	"github.com/Masterminds/sprig"
 
+	"github.com/rverton/pipers/asset"
 	"github.com/rverton/pipers/db"
 	"github.com/rverton/pipers/notification"
+	"github.com/rverton/pipers/scope"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 
@@ -28,6 +31,15 @@ import (
 const INTERVAL_DEFAULT = "24h"
 const TIMEOUT_DEFAULT = "1h"
 
+// types of alerts, a record which was marked as removed and is
+// produced again reappears
+const (
+	ALERT_CREATED    = "CREATED"
+	ALERT_UPDATED    = "UPDATED"
+	ALERT_REMOVED    = "REMOVED"
+	ALERT_REAPPEARED = "REAPPEARED"
+)
+
 type Pipe struct {
 	Name  string
 	Input struct {
@@ -35,7 +47,13 @@ type Pipe struct {
 		Table     string
 		Filter    map[string]string
 		Threshold map[string]string
+		Where     string // filter expression, see db.Filter
 		AsFile    string `yaml:"as_file"`
+
+		// only pass records created since the last run, with a full
+		// refresh every number of intervals if set
+		Incremental bool
+		FullRefresh int `yaml:"full_refresh"`
 	}
 	Command string            `yaml:"cmd"`
 	Filter  map[string]string // JS filter
@@ -43,7 +61,19 @@ type Pipe struct {
 		Table string
 		Ident string
 		Asset string
-		Data  map[string]string
+		Data  OutputData
+		Types map[string]string // types of data fields, see FIELD_TYPES
+		Track []string          // fields which update a record on change
+
+		// validation and normalization of the asset, defaults to domain
+		AssetType asset.Type `yaml:"asset_type"`
+
+		// records not seen again are marked as removed after a number
+		// of missed runs or a duration, whichever is reached first
+		Removed struct {
+			MissedRuns int    `yaml:"missed_runs"`
+			After      string // time.Duration format
+		}
 	}
 	IntervalValue string `yaml:"interval"` // time.Duration format
 	TimeoutValue  string `yaml:"timeout"`  // time.Duration format
@@ -66,6 +96,95 @@ func (p Pipe) Timeout() (time.Duration, error) {
 	return time.ParseDuration(p.TimeoutValue)
 }
 
+// Source returns the namespace in which runs of this pipe are tracked.
+// Table inputs use the table name, targets of as_file pipes and lines of
+// input files are prefixed, so they can not collide with record ids.
+func (p Pipe) Source() string {
+	switch {
+	case p.Input.File != "":
+		return "file:" + filepath.Base(p.Input.File)
+	case p.Input.AsFile != "":
+		return "target:" + p.Input.Table
+	default:
+		return p.Input.Table
+	}
+}
+
+// source returns the input an output was produced from. Outputs of
+// as_file pipes are linked to the most specific input record covering
+// their asset, see scope.Covers. If none does, they are linked to the
+// input of the whole target.
+func (p Pipe) source(ctx context.Context, data db.Data, output string) *db.Source {
+	src := &db.Source{Table: p.Source(), Ident: data.Id, TaskId: TaskId(ctx)}
+
+	if p.Input.AsFile == "" || output == "" {
+		return src
+	}
+
+	inputs, _ := data.Data["as_file_inputs"].(map[string]interface{})
+
+	parent := ""
+	for ident, v := range inputs {
+		input, _ := v.(string)
+		if input == "" || !scope.Covers(input, output) {
+			continue
+		}
+
+		if src.Table == p.Input.Table && (len(input) < len(parent) || len(input) == len(parent) && ident > src.Ident) {
+			continue
+		}
+		src.Table, src.Ident, parent = p.Input.Table, ident, input
+	}
+
+	return src
+}
+
+// RemovedAfter returns the duration after which a record which was not
+// produced again is marked as removed, zero if this is disabled
+func (p Pipe) RemovedAfter() (time.Duration, error) {
+	var after time.Duration
+
+	if p.Output.Removed.After != "" {
+		d, err := time.ParseDuration(p.Output.Removed.After)
+		if err != nil {
+			return 0, err
+		}
+		after = d
+	}
+
+	if p.Output.Removed.MissedRuns > 0 {
+		interval, err := p.Interval()
+		if err != nil {
+			return 0, err
+		}
+
+		missed := interval * time.Duration(p.Output.Removed.MissedRuns)
+		if after == 0 || missed < after {
+			after = missed
+		}
+	}
+
+	return after, nil
+}
+
+// FullRefresh returns how often all records are passed to an
+// incremental pipe, zero disables full refreshes
+func (p Pipe) FullRefresh() time.Duration {
+	interval, _ := p.Interval()
+	return interval * time.Duration(p.Input.FullRefresh)
+}
+
+// InputFilter combines the filter, threshold and where conditions
+// of the input
+func (p Pipe) InputFilter() (db.Filter, error) {
+	where, err := db.ParseFilter(p.Input.Where)
+	if err != nil {
+		return db.Filter{}, err
+	}
+
+	return db.FieldsFilter(p.Input.Filter, p.Input.Threshold).And(where), nil
+}
+
 func (p Pipe) Ident(tplData map[string]interface{}) (string, error) {
 	if p.Output.Ident == "" {
 		return "", fmt.Errorf("ident field is empty")
@@ -82,6 +201,14 @@ func (p Pipe) AlertMsg(tplData map[string]interface{}) (string, error) {
 	return Tpl(p.AlertMsgValue, tplData)
 }
 
+// AssetType returns the type of output assets
+func (p Pipe) AssetType() asset.Type {
+	if p.Output.AssetType == "" {
+		return asset.TYPE_DEFAULT
+	}
+	return p.Output.AssetType
+}
+
 func (p Pipe) validate() error {
 	if _, err := p.Interval(); err != nil {
 		return fmt.Errorf("invalid date interval: %w", err)
@@ -91,6 +218,42 @@ func (p Pipe) validate() error {
 		return fmt.Errorf("empty command")
 	}
 
+	if _, err := p.RemovedAfter(); err != nil {
+		return fmt.Errorf("invalid removed duration: %w", err)
+	}
+
+	for k, v := range p.Input.Threshold {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("invalid threshold for %v: %w", k, err)
+		}
+	}
+
+	if _, err := p.InputFilter(); err != nil {
+		return fmt.Errorf("invalid input where: %w", err)
+	}
+
+	if p.Input.Incremental && (p.Input.Table == "" || p.Input.File != "") {
+		return fmt.Errorf("incremental input requires a table")
+	}
+
+	if p.Input.FullRefresh < 0 || (p.Input.FullRefresh > 0 && !p.Input.Incremental) {
+		return fmt.Errorf("full_refresh requires an incremental input")
+	}
+
+	// incremental inputs only pass old records again on a full refresh,
+	// their outputs would be removed otherwise
+	if after, _ := p.RemovedAfter(); p.Input.Incremental && after > 0 && (p.FullRefresh() == 0 || p.FullRefresh() >= after) {
+		return fmt.Errorf("incremental input with output.removed requires a full_refresh shorter than the removal window")
+	}
+
+	if !p.AssetType().Valid() {
+		return fmt.Errorf("invalid asset_type %q", p.Output.AssetType)
+	}
+
+	if err := validateTypes(p.Output.Data, p.Output.Types); err != nil {
+		return fmt.Errorf("invalid output types: %w", err)
+	}
+
 	return nil
 }
 
@@ -143,13 +306,13 @@ func (p Pipe) outputMap(tplData map[string]interface{}) map[string]interface{} {
 	data := make(map[string]interface{})
 
 	for name, val := range p.Output.Data {
-		s, err := Tpl(val, tplData)
+		v, err := renderField(name, val, p.Output.Types, tplData)
 		if err != nil {
-			log.WithFields(log.Fields{"template": val}).Errorf("cant create template: %v", err)
+			log.WithFields(log.Fields{"field": name}).Errorf("cant create output field: %v", err)
 			continue
 		}
 
-		data[name] = s
+		data[name] = v
 	}
 
 	s, err := Tpl(p.Output.Asset, tplData)
@@ -187,7 +350,21 @@ func (p Pipe) filter(vm *otto.Otto, output string) (bool, string, error) {
 	return false, "", nil
 }
 
-func Process(ctx context.Context, p Pipe, data db.Data, ds db.DataService) error {
+type taskIdKey struct{}
+
+// WithTaskId adds the id of a queued task to the context of Process,
+// it is recorded in the lineage of saved records
+func WithTaskId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, taskIdKey{}, id)
+}
+
+// TaskId returns the task id of a context, empty if none was set
+func TaskId(ctx context.Context) string {
+	id, _ := ctx.Value(taskIdKey{}).(string)
+	return id
+}
+
+func Process(ctx context.Context, p Pipe, data db.Data, ds db.RecordService) error {
 	start := time.Now()
 	logger := log.WithField("pipe", p.Name)
 
@@ -217,8 +394,8 @@ func Process(ctx context.Context, p Pipe, data db.Data, ds db.DataService) error
 	// initialize new JS engine for filtering
 	vm := otto.New()
 
-	// blocked domains
-	blocked, err := ds.RetrieveBlocked()
+	// excluded assets of the target
+	excluded, err := ExcludedRules(ds, data.Target)
 	if err != nil {
 		return fmt.Errorf("cant retrieve blocklist: %v\n", err)
 	}
@@ -265,43 +442,71 @@ func Process(ctx context.Context, p Pipe, data db.Data, ds db.DataService) error
 			continue
 		}
 
-		asset := data.Asset
+		raw := data.Asset
 		if v, ok := output["asset"].(string); ok && v != "" {
-			asset = v
+			raw = v
 		}
 
-		if err := validateDomain(asset); err != nil {
-			logger.WithFields(log.Fields{
-				"ident": id,
-				"asset": asset,
-			}).Infof("invalid asset, skipping")
-
-			continue
+		// outputs without an asset are kept unless their asset_type
+		// requires one, like before asset types were added
+		normalized := raw
+		if strings.TrimSpace(raw) != "" || (p.Output.AssetType != "" && p.Output.AssetType != asset.TEXT) {
+			normalized, err = asset.Normalize(p.AssetType(), raw)
+			if err != nil {
+				logger.WithFields(log.Fields{
+					"ident": id,
+					"asset": raw,
+					"type":  p.AssetType(),
+					"error": err,
+				}).Infof("invalid asset, skipping")
+
+				continue
+			}
 		}
+		output["asset"] = normalized
 
-		if isBlockedDomain(asset, blocked) {
+		if d := CheckScope(data.Target, normalized, excluded); !d.InScope {
 			logger.WithFields(log.Fields{
-				"ident": id,
-				"asset": asset,
-			}).Infof("blocked asset, skipping")
+				"ident":  id,
+				"asset":  normalized,
+				"rule":   d.Rule,
+				"reason": d.Reason,
+			}).Infof("asset not in scope, skipping")
 			continue
 		}
 
-		inserted, err := ds.Save(p.Output.Table, p.Name, id, data, output)
+		res, err := ds.Save(p.Output.Table, p.Name, id, data, output, db.SaveOptions{
+			Track:     p.Output.Track,
+			AssetType: string(p.AssetType()),
+			Source:    p.source(ctx, data, normalized),
+		})
 		if err != nil {
 			logger.WithField("ident", id).Errorf("unable to save: %v", err)
 			continue
 		}
 
-		if inserted {
+		if res.Inserted || res.Updated || res.Reactivated {
 			msg, err := p.AlertMsg(tplData)
 			if err != nil {
 				log.WithField("error", err).Errorf("generating alert failed")
-			} else if msg != "" {
+			}
+
+			alertType := ALERT_CREATED
+			switch {
+			case res.Reactivated:
+				alertType = ALERT_REAPPEARED
+			case res.Updated:
+				alertType = ALERT_UPDATED
+			}
+			if res.Updated {
+				msg = strings.TrimSpace(fmt.Sprintf("%v [%v]", msg, res.Changes))
+			}
+
+			if msg != "" {
 				notifyText += msg + "\n"
 			}
 
-			if err := ds.SaveAlert(p.Name, id, msg, "CREATED"); err != nil {
+			if err := ds.SaveAlert(p.Name, data.Target, id, msg, alertType); err != nil {
 				log.WithField("ident", id).Errorf("cant create alert: %v", err)
 			}
 		}
@@ -347,6 +552,47 @@ func Process(ctx context.Context, p Pipe, data db.Data, ds db.DataService) error
 	return nil
 }
 
+// Sweep marks records of the pipe which were not seen again for too
+// long as removed and creates REMOVED alerts for them
+func Sweep(p Pipe, ds db.RecordService) error {
+	after, err := p.RemovedAfter()
+	if err != nil || after <= 0 {
+		return err
+	}
+
+	removed, err := ds.MarkRemoved(p.Output.Table, p.Name, after)
+	if err != nil {
+		return fmt.Errorf("cant mark removed records: %v", err)
+	}
+
+	var notifyText string
+
+	for _, r := range removed {
+		msg := fmt.Sprintf("Removed '%v'", r.Asset)
+		notifyText += msg + "\n"
+
+		if err := ds.SaveAlert(p.Name, r.Target, r.Id, msg, ALERT_REMOVED); err != nil {
+			log.WithField("ident", r.Id).Errorf("cant create alert: %v", err)
+		}
+	}
+
+	if len(removed) > 0 {
+		log.WithFields(log.Fields{
+			"pipe":    p.Name,
+			"removed": len(removed),
+		}).Info("marked records as removed")
+	}
+
+	if len(notifyText) > 0 {
+		notifyText = fmt.Sprintf("*[%v]*\n%v", p.Name, notifyText)
+		if err := notification.Notify(notifyText); err != nil {
+			log.Errorf("slack webhook failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
 func Load(filename string) (Pipe, error) {
 	var pipe Pipe
 	f, err := ioutil.ReadFile(filename)
@@ -392,6 +638,7 @@ func LoadMultiple(glob string) ([]Pipe, error) {
 func MapInput(data db.Data) map[string]interface{} {
 	input := data.Data
 	input["asset"] = data.Asset
+	input["asset_type"] = data.AssetType
 	input["target"] = data.Target
 	return input
 }
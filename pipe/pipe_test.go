@@ -0,0 +1,446 @@
+package pipe
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rverton/pipers/asset"
+	"github.com/rverton/pipers/db"
+	"github.com/rverton/pipers/scope"
+)
+
+func testPipe() Pipe {
+	var p Pipe
+	p.Name = "test_pipe"
+	p.Command = "printf 'a.${.input.asset}\\nb.${.input.asset}\\n'"
+	p.Output.Table = "domains"
+	p.Output.Ident = "${.output}"
+	p.Output.Asset = "${.output}"
+	p.Output.Data = OutputData{"source": "${.input.asset}"}
+	p.AlertMsgValue = "new ${.output}"
+	return p
+}
+
+func TestProcess(t *testing.T) {
+	ds := &db.MemoryService{}
+	p := testPipe()
+
+	data := db.Data{Asset: "example.com", Target: "example", Data: map[string]interface{}{}}
+
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	records := ds.Records("domains")
+	if len(records) != 2 {
+		t.Fatalf("want = 2 records, got = %v", len(records))
+	}
+
+	for _, r := range records {
+		if r.Target != "example" || r.Data["source"] != "example.com" {
+			t.Errorf("unexpected record %+v", r)
+		}
+	}
+
+	if got := len(ds.Alerts()); got != 2 {
+		t.Errorf("want = 2 alerts, got = %v", got)
+	}
+
+	// running again must not create new records or alerts
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(ds.Records("domains")); got != 2 {
+		t.Errorf("want = 2 records, got = %v", got)
+	}
+
+	if got := len(ds.Alerts()); got != 2 {
+		t.Errorf("want = 2 alerts, got = %v", got)
+	}
+}
+
+func TestProcessBlocked(t *testing.T) {
+	ds := &db.MemoryService{}
+	ds.Insert("domains", db.Data{Id: "b.example.com", Asset: "b.example.com", Target: "example"}, true)
+
+	data := db.Data{Asset: "example.com", Target: "example", Data: map[string]interface{}{}}
+
+	if err := Process(context.Background(), testPipe(), data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	records := ds.Records("domains")
+	if len(records) != 2 {
+		t.Fatalf("want = 2 records, got = %v", len(records))
+	}
+
+	if got := len(ds.Alerts()); got != 1 || ds.Alerts()[0].Ident != "a.example.com" {
+		t.Errorf("want a single alert for a.example.com, got %+v", ds.Alerts())
+	}
+}
+
+func TestProcessScope(t *testing.T) {
+	ds := &db.MemoryService{}
+
+	// an exclusion must not block other domains sharing its suffix
+	ds.Insert("domains", db.Data{Id: "ample.com", Asset: "ample.com", Target: "example"}, true)
+
+	if err := LoadScope(scope.Targets{"example": {Out: []string{"b.example.com"}}}); err != nil {
+		t.Fatal(err)
+	}
+	defer LoadScope(nil)
+
+	data := db.Data{Asset: "example.com", Target: "example", Data: map[string]interface{}{}}
+
+	if err := Process(context.Background(), testPipe(), data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(ds.Alerts()); got != 1 || ds.Alerts()[0].Ident != "a.example.com" {
+		t.Errorf("want a single alert for a.example.com, got %+v", ds.Alerts())
+	}
+}
+
+func TestProcessAssetType(t *testing.T) {
+	ds := &db.MemoryService{}
+
+	p := testPipe()
+	p.Command = "printf 'HTTPS://${.input.asset}:443/#a\\nnot a url\\n'"
+	p.Output.AssetType = asset.URL
+
+	data := db.Data{Asset: "Example.com", Target: "example", Data: map[string]interface{}{}}
+
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	records := ds.Records("domains")
+	if len(records) != 1 {
+		t.Fatalf("want = 1 record, got = %+v", records)
+	}
+
+	if r := records[0]; r.Asset != "https://example.com/" || r.AssetType != "url" {
+		t.Errorf("want normalized url asset, got %+v", r)
+	}
+
+	p.Output.AssetType = "foo"
+	if err := p.validate(); err == nil {
+		t.Errorf("want unknown asset_type to be invalid")
+	}
+}
+
+func TestProcessWithoutAsset(t *testing.T) {
+	p := testPipe()
+	p.Output.Asset = ""
+
+	data := db.Data{Target: "example", Data: map[string]interface{}{}}
+
+	tests := []struct {
+		assetType asset.Type
+		want      int
+	}{
+		{"", 2},
+		{asset.TEXT, 2},
+		{asset.DOMAIN, 0},
+	}
+
+	for _, tt := range tests {
+		ds := &db.MemoryService{}
+		p.Output.AssetType = tt.assetType
+
+		if err := Process(context.Background(), p, data, ds); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(ds.Records("domains")); got != tt.want {
+			t.Errorf("%q: want = %v records, got = %v", tt.assetType, tt.want, got)
+		}
+	}
+}
+
+func TestProcessLineage(t *testing.T) {
+	ds := &db.MemoryService{}
+	p := testPipe()
+	p.Input.Table = "seeds"
+
+	data := db.Data{Id: "example.com", Asset: "example.com", Target: "example", Data: map[string]interface{}{}}
+
+	if err := Process(WithTaskId(context.Background(), "t1"), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	edges, _ := ds.Lineage("seeds", "example", "example.com", true)
+	if len(edges) != 2 {
+		t.Fatalf("want = 2 edges, got = %+v", edges)
+	}
+
+	for _, e := range edges {
+		if e.Table != "domains" || e.SourceTable != "seeds" || e.TaskId != "t1" || e.Pipe != p.Name {
+			t.Errorf("unexpected edge %+v", e)
+		}
+	}
+}
+
+func TestProcessAsFileLineage(t *testing.T) {
+	ds := &db.MemoryService{}
+
+	f, err := ioutil.TempFile(os.TempDir(), "pipers-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("example.com\nsub.example.com\nexample.org\n")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	p := testPipe()
+	p.Input.Table = "seeds"
+	p.Input.AsFile = "${.input.asset}"
+	p.Command = "printf 'a.sub.example.com\\nwww.example.com\\nunrelated.net\\n'"
+
+	data := db.Data{Id: "example", Target: "example", Data: map[string]interface{}{
+		"as_file": f.Name(),
+		"as_file_inputs": map[string]interface{}{
+			"example.com":     "example.com",
+			"sub.example.com": "sub.example.com",
+			"example.org":     "example.org",
+		},
+	}}
+
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	// outputs are linked to the most specific input covering them
+	want := map[string]string{
+		"a.sub.example.com": "seeds:sub.example.com",
+		"www.example.com":   "seeds:example.com",
+		"unrelated.net":     "target:seeds:example",
+	}
+
+	for ident, source := range want {
+		edges, _ := ds.Lineage("domains", "example", ident, false)
+		if len(edges) != 1 || edges[0].SourceTable+":"+edges[0].SourceIdent != source {
+			t.Errorf("%v: want source %v, got %+v", ident, source, edges)
+		}
+	}
+}
+
+func TestProcessTracked(t *testing.T) {
+	ds := &db.MemoryService{}
+
+	p := testPipe()
+	p.Command = "echo ${.input.status}"
+	p.Output.Ident = "${.input.asset}"
+	p.Output.Asset = "${.input.asset}"
+	p.Output.Data = OutputData{"status": "${.output}"}
+	p.Output.Track = []string{"status"}
+	p.AlertMsgValue = ""
+
+	for _, status := range []string{"200", "200", "404"} {
+		data := db.Data{Asset: "example.com", Target: "example", Data: map[string]interface{}{"status": status}}
+		if err := Process(context.Background(), p, data, ds); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alerts := ds.Alerts()
+	if len(alerts) != 2 || alerts[0].Type != "CREATED" || alerts[1].Type != "UPDATED" {
+		t.Fatalf("want CREATED and UPDATED alerts, got %+v", alerts)
+	}
+
+	if alerts[1].Message != "[status: '200' -> '404']" {
+		t.Errorf("unexpected alert message %q", alerts[1].Message)
+	}
+
+	if got := len(ds.History()); got != 1 {
+		t.Errorf("want = 1 history entry, got = %v", got)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	ds := &db.MemoryService{}
+
+	p := testPipe()
+	p.Output.Removed.After = "1ns"
+
+	data := db.Data{Asset: "example.com", Target: "example", Data: map[string]interface{}{}}
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Sweep(p, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	var removed []db.Alert
+	for _, a := range ds.Alerts() {
+		if a.Type == "REMOVED" {
+			removed = append(removed, a)
+		}
+	}
+
+	if len(removed) != 2 {
+		t.Fatalf("want = 2 REMOVED alerts, got %+v", ds.Alerts())
+	}
+
+	// records are reactivated once they are produced again
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	alerts := ds.Alerts()
+	if got := len(alerts); got != 6 {
+		t.Fatalf("want = 6 alerts, got = %v", got)
+	}
+	for _, a := range alerts[4:] {
+		if a.Type != ALERT_REAPPEARED {
+			t.Errorf("want reactivated records to reappear, got %+v", a)
+		}
+	}
+}
+
+func TestSweepResumed(t *testing.T) {
+	ds := &db.MemoryService{}
+
+	p := testPipe()
+	p.Output.Removed.After = "100ms"
+
+	data := db.Data{Asset: "example.com", Target: "example", Data: map[string]interface{}{}}
+	if err := Process(context.Background(), p, data, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	// records are not refreshed while the pipe is paused
+	ds.PausePipe(p.Name, true)
+	time.Sleep(200 * time.Millisecond)
+
+	if err := Sweep(p, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	ds.PausePipe(p.Name, false)
+
+	if err := Sweep(p, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, a := range ds.Alerts() {
+		if a.Type == ALERT_REMOVED {
+			t.Fatalf("want no REMOVED alerts right after resume, got %+v", a)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := Sweep(p, ds); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(ds.Alerts()); got != 4 || ds.Alerts()[3].Type != ALERT_REMOVED {
+		t.Errorf("want records to be removed one window after resume, got %+v", ds.Alerts())
+	}
+}
+
+func TestRemovedAfter(t *testing.T) {
+	p := testPipe()
+	p.IntervalValue = "1h"
+	p.Output.Removed.MissedRuns = 3
+
+	if after, _ := p.RemovedAfter(); after != 3*time.Hour {
+		t.Errorf("want = 3h, got = %v", after)
+	}
+
+	p.Output.Removed.After = "2h"
+	if after, _ := p.RemovedAfter(); after != 2*time.Hour {
+		t.Errorf("want = 2h, got = %v", after)
+	}
+}
+
+func TestSource(t *testing.T) {
+	p := testPipe()
+	p.Input.Table = "domains"
+
+	if got := p.Source(); got != "domains" {
+		t.Errorf("want = domains, got = %v", got)
+	}
+
+	p.Input.AsFile = "${.input.asset}"
+	if got := p.Source(); got != "target:domains" {
+		t.Errorf("want = target:domains, got = %v", got)
+	}
+
+	p.Input.File = "/tmp/hosts.txt"
+	if got := p.Source(); got != "file:hosts.txt" {
+		t.Errorf("want = file:hosts.txt, got = %v", got)
+	}
+}
+
+func TestInputFilter(t *testing.T) {
+	p := testPipe()
+	p.Input.Filter = map[string]string{"service": "http"}
+	p.Input.Where = "status in (200, 401) or title ~ 'admin'"
+
+	if err := p.validate(); err != nil {
+		t.Errorf("want valid where, got %v", err)
+	}
+
+	if f, _ := p.InputFilter(); f.Empty() {
+		t.Errorf("want filter to be combined")
+	}
+
+	p.Input.Where = "status in (200"
+	if err := p.validate(); err == nil {
+		t.Errorf("want error for invalid where")
+	}
+
+	p.Input.Where = ""
+	p.Input.Threshold = map[string]string{"score": "high"}
+	if err := p.validate(); err == nil {
+		t.Errorf("want error for invalid threshold")
+	}
+}
+
+func TestIncremental(t *testing.T) {
+	p := testPipe()
+	p.IntervalValue = "1h"
+	p.Input.Table = "domains"
+	p.Input.FullRefresh = 24
+
+	if err := p.validate(); err == nil {
+		t.Errorf("want error for full_refresh without incremental")
+	}
+
+	p.Input.Incremental = true
+	if err := p.validate(); err != nil {
+		t.Errorf("want valid incremental input, got %v", err)
+	}
+
+	if got := p.FullRefresh(); got != 24*time.Hour {
+		t.Errorf("want = 24h, got = %v", got)
+	}
+
+	p.Output.Removed.MissedRuns = 24
+	if err := p.validate(); err == nil {
+		t.Errorf("want error for removal window not longer than full_refresh")
+	}
+
+	p.Output.Removed.MissedRuns = 48
+	if err := p.validate(); err != nil {
+		t.Errorf("want valid removal after full_refresh, got %v", err)
+	}
+
+	p.Input.FullRefresh = 0
+	if err := p.validate(); err == nil {
+		t.Errorf("want error for removal without full_refresh")
+	}
+	p.Input.FullRefresh = 24
+
+	p.Input.File = "domains.txt"
+	if err := p.validate(); err == nil {
+		t.Errorf("want error for incremental file input")
+	}
+}
@@ -0,0 +1,77 @@
+package pipe
+
+import (
+	"github.com/rverton/pipers/db"
+	"github.com/rverton/pipers/scope"
+	log "github.com/sirupsen/logrus"
+)
+
+var scopeEngine *scope.Engine
+
+// LoadScope parses the scope rules of all targets
+func LoadScope(targets scope.Targets) error {
+	e, err := scope.New(targets)
+	if err != nil {
+		return err
+	}
+
+	scopeEngine = e
+
+	log.WithFields(log.Fields{"targets": len(targets)}).Info("scope rules loaded")
+
+	return nil
+}
+
+// ExcludedRules converts the excluded assets of a target to out of
+// scope rules
+func ExcludedRules(ds db.RecordService, target string) ([]scope.Rule, error) {
+	blocked, err := ds.RetrieveBlocked(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []scope.Rule
+	for _, asset := range blocked {
+		r, err := scope.ExclusionRules(asset)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"asset": asset,
+				"error": err,
+			}).Warn("ignoring invalid exclusion")
+			continue
+		}
+		rules = append(rules, r...)
+	}
+
+	return rules, nil
+}
+
+// CheckScope checks an asset against the scope rules and exclusions of
+// its target
+func CheckScope(target, asset string, excluded []scope.Rule) scope.Decision {
+	return scopeEngine.Check(target, asset, excluded)
+}
+
+// Exclusions caches the excluded rules of targets during a scheduler run
+type Exclusions struct {
+	ds    db.RecordService
+	rules map[string][]scope.Rule
+}
+
+func NewExclusions(ds db.RecordService) *Exclusions {
+	return &Exclusions{ds: ds, rules: make(map[string][]scope.Rule)}
+}
+
+// Check works like CheckScope and loads exclusions of a target once
+func (e *Exclusions) Check(target, asset string) (scope.Decision, error) {
+	rules, ok := e.rules[target]
+	if !ok {
+		var err error
+		if rules, err = ExcludedRules(e.ds, target); err != nil {
+			return scope.Decision{}, err
+		}
+		e.rules[target] = rules
+	}
+
+	return CheckScope(target, asset, rules), nil
+}
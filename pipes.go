@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rverton/pipers/db"
+	"github.com/rverton/pipers/pipe"
+)
+
+const pipeUsage = "usage: pipe <list|pause|resume> [name]"
+
+// pipeCommand runs the pipe command, which lists the loaded pipes and
+// pauses or resumes them without restarting scheduler and workers
+func pipeCommand(args []string, ds db.PauseService, pipes []pipe.Pipe) error {
+	if len(args) == 0 {
+		return fmt.Errorf(pipeUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return pipeList(args[1:], ds, pipes)
+	case "pause", "resume":
+		return pipePause(args[0], args[1:], ds, pipes)
+	}
+
+	return fmt.Errorf(pipeUsage)
+}
+
+// pipeList prints all loaded pipes with their status
+func pipeList(args []string, ds db.PauseService, pipes []pipe.Pipe) error {
+	fs := flag.NewFlagSet("pipe list", flag.ExitOnError)
+	fs.Parse(args)
+
+	paused, err := ds.RetrievePaused()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tSTATUS\tINPUT\tOUTPUT\tINTERVAL\n")
+
+	for _, p := range pipes {
+		status := "active"
+		if paused.Pipes[p.Name] {
+			status = "paused"
+		}
+
+		input := p.Input.Table
+		if p.Input.File != "" {
+			input = p.Input.File
+		}
+
+		interval, _ := p.Interval()
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", p.Name, status, input, p.Output.Table, interval)
+	}
+
+	return w.Flush()
+}
+
+// pipePause pauses or resumes the scheduling and processing of a pipe
+func pipePause(action string, args []string, ds db.PauseService, pipes []pipe.Pipe) error {
+	fs := flag.NewFlagSet("pipe "+action, flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: pipe %v <name>", action)
+	}
+
+	name := fs.Arg(0)
+
+	known := false
+	for _, p := range pipes {
+		known = known || p.Name == name
+	}
+	if !known {
+		return fmt.Errorf("pipe %v not found", name)
+	}
+
+	if err := ds.PausePipe(name, action == "pause"); err != nil {
+		return err
+	}
+
+	fmt.Printf("%vd pipe %v\n", action, name)
+	return nil
+}
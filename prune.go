@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rverton/pipers/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// prune runs the prune command, which deletes all rows exceeding the
+// retention policy or only prints their count with -dry-run
+func prune(args []string, ds db.AdminService, policy db.RetentionPolicy) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print how many rows would be deleted")
+	fs.Parse(args)
+
+	if !policy.Enabled() {
+		return fmt.Errorf("no retention policy configured")
+	}
+
+	results, err := db.Prune(ds, policy, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	action := "DELETED"
+	if *dryRun {
+		action = "WOULD DELETE"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TABLE\tTARGET\tMAX AGE\t%v\n", action)
+	for _, r := range results {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", r.Table, r.Target, r.MaxAge, r.Deleted)
+	}
+	return w.Flush()
+}
+
+// pruneLoop is started by the scheduler and periodically applies
+// the retention policy
+func pruneLoop(ds db.AdminService, policy db.RetentionPolicy) {
+	interval, _ := policy.PruneInterval()
+
+	for {
+		results, err := db.Prune(ds, policy, false)
+		if err != nil {
+			log.WithField("error", err).Error("pruning failed")
+		}
+
+		for _, r := range results {
+			if r.Deleted > 0 {
+				log.WithFields(log.Fields{
+					"table":   r.Table,
+					"target":  r.Target,
+					"deleted": r.Deleted,
+				}).Info("pruned rows")
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rverton/pipers/db"
+)
+
+// formats of query results
+const (
+	QUERY_TABLE  = "table"
+	QUERY_JSON   = "json"
+	QUERY_ASSETS = "assets"
+)
+
+// fieldsFlag collects key=value pairs like the filter and threshold
+// maps of pipe inputs
+type fieldsFlag map[string]string
+
+func (f fieldsFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f fieldsFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("want key=value, got %q", s)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
+
+// errLimit stops a query after its limit was reached
+var errLimit = errors.New("limit reached")
+
+// tableWriter prints rows aligned like the other commands
+type tableWriter struct {
+	w       *tabwriter.Writer
+	columns []column
+	header  bool
+}
+
+func (w *tableWriter) Write(r db.Row) error {
+	if !w.header {
+		var names []string
+		for _, c := range w.columns {
+			names = append(names, strings.ToUpper(c.name))
+		}
+		fmt.Fprintf(w.w, "%v\n", strings.Join(names, "\t"))
+		w.header = true
+	}
+
+	var cells []string
+	for _, c := range w.columns {
+		cells = append(cells, cellText(c.value(r)))
+	}
+	_, err := fmt.Fprintf(w.w, "%v\n", strings.Join(cells, "\t"))
+	return err
+}
+
+func (w *tableWriter) Flush() error { return w.w.Flush() }
+
+// assetWriter prints each distinct asset on its own line
+type assetWriter struct {
+	seen map[string]bool
+}
+
+func (w *assetWriter) Write(r db.Row) error {
+	asset, _ := r["asset"].(string)
+	if w.seen[asset] {
+		return nil
+	}
+	w.seen[asset] = true
+
+	_, err := fmt.Println(asset)
+	return err
+}
+
+func (w *assetWriter) Flush() error { return nil }
+
+// query runs the query command, which prints the records of a data
+// table passed to pipes, filtered like pipe inputs
+func query(args []string, ds db.AdminService) error {
+	filter := make(fieldsFlag)
+	threshold := make(fieldsFlag)
+
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	target := fs.String("target", "", "only records of this target")
+	pipeName := fs.String("pipe", "", "only records saved by this pipe")
+	fs.Var(filter, "filter", "data field equal to a value as key=value, can be repeated")
+	fs.Var(threshold, "threshold", "data field greater than a number as key=value, can be repeated")
+	where := fs.String("where", "", "filter expression, like the where of pipe inputs")
+	since := fs.String("since", "", "only records created since a date or a duration ago, like 24h")
+	until := fs.String("until", "", "only records created before a date or a duration ago")
+	all := fs.Bool("all", false, "include excluded and removed records")
+	format := fs.String("format", QUERY_TABLE, "table, json or assets")
+	fields := fs.String("fields", "", "comma separated data fields shown as table columns")
+	limit := fs.Int("limit", 0, "print at most this many records")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: query [-target name] [-pipe name] [-filter k=v] [-threshold k=n] [-where expr] [-since t] [-until t] [-all] [-format f] [-fields a,b] [-limit n] <table>")
+	}
+
+	for k, v := range threshold {
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("invalid threshold for %v: %v", k, err)
+		}
+	}
+
+	whereFilter, err := db.ParseFilter(*where)
+	if err != nil {
+		return fmt.Errorf("invalid filter: %v", err)
+	}
+
+	q := db.ExportQuery{
+		Target: *target,
+		Pipe:   *pipeName,
+		Filter: db.FieldsFilter(filter, threshold).And(whereFilter),
+		Active: !*all,
+	}
+
+	if q.Since, err = parseTime(*since); err != nil {
+		return err
+	}
+	if q.Until, err = parseTime(*until); err != nil {
+		return err
+	}
+
+	var w rowWriter
+	switch *format {
+	case QUERY_TABLE:
+		var dataFields []string
+		if *fields != "" {
+			dataFields = strings.Split(*fields, ",")
+		}
+
+		columns := []column{}
+		for _, c := range exportColumns(fs.Arg(0), dataFields) {
+			switch c.name {
+			case "asset_type", "exclude", "active", "last_seen":
+				continue
+			}
+			columns = append(columns, c)
+		}
+
+		w = &tableWriter{w: tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0), columns: columns}
+	case QUERY_JSON:
+		w, _ = newRowWriter(os.Stdout, EXPORT_JSONL, nil)
+	case QUERY_ASSETS:
+		w = &assetWriter{seen: make(map[string]bool)}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	n := 0
+	err = ds.Export(fs.Arg(0), q, func(r db.Row) error {
+		if *limit > 0 && n >= *limit {
+			return errLimit
+		}
+		n++
+		return w.Write(r)
+	})
+	if err != nil && err != errLimit {
+		return err
+	}
+
+	return w.Flush()
+}
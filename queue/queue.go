@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"time"
 
 	"github.com/hibiken/asynq"
+	"github.com/rverton/pipers/asset"
 	"github.com/rverton/pipers/db"
 	"github.com/rverton/pipers/pipe"
 
@@ -93,20 +95,58 @@ func Handler(ctx context.Context, t *asynq.Task, ds db.DataService) error {
 		data.Data = make(map[string]interface{})
 	}
 
-	// add task log
-	if err := ds.AddTask(db.Task{
-		Pipe:  p.Name,
-		Ident: data.Id,
-	}); err != nil {
+	// tasks queued before a pipe or target was paused are dropped
+	// without a task log, so they are due again once resumed
+	paused, err := ds.RetrievePaused()
+	if err != nil {
+		return fmt.Errorf("retrieving paused pipes and targets failed: %v", err)
+	}
+
+	if reason := paused.Reason(p.Name, data.Target); reason != "" {
+		log.WithFields(log.Fields{
+			"pipe":   p.Name,
+			"target": data.Target,
+			"ident":  data.Id,
+			"reason": reason,
+		}).Info("skipping paused task")
+
+		removeAsFile(p, data)
+		return nil
+	}
+
+	// do not process assets pointing to blocked addresses, as_file
+	// tasks contain the assets of a whole target and are not checked
+	host := pipe.HostDecision{Valid: true}
+	if p.Input.AsFile == "" {
+		host = pipe.CheckHost(data.Target, asset.Type(data.AssetType), data.Asset)
+	}
+
+	taskId, _ := asynq.GetTaskID(ctx)
+	ctx = pipe.WithTaskId(ctx, taskId)
+
+	// add task log, a skipped task notes the reason
+	task := db.Task{
+		Pipe:   p.Name,
+		Table:  p.Source(),
+		Target: data.Target,
+		Ident:  data.Id,
+		TaskId: taskId,
+	}
+	if !host.Valid {
+		task.Note = "skipped: " + host.Reason
+	}
+
+	if err := ds.AddTask(task); err != nil {
 		log.WithFields(log.Fields{"error": err}).Errorf("unable to add task")
 	}
 
-	// do not enqueue invalid assets
-	if !pipe.IsValidHost(data.Asset) {
+	if !host.Valid {
 		log.WithFields(log.Fields{
-			"pipe":  p.Name,
-			"asset": data.Asset,
-		}).Info("skipping asset pointing to blacklisted IP")
+			"pipe":   p.Name,
+			"asset":  data.Asset,
+			"reason": host.Reason,
+		}).Info("skipping asset")
+		removeAsFile(p, data)
 		return nil
 	}
 
@@ -117,6 +157,16 @@ func Handler(ctx context.Context, t *asynq.Task, ds db.DataService) error {
 	return nil
 }
 
+// removeAsFile removes the input file of an as_file task which is not
+// processed, Process removes it otherwise
+func removeAsFile(p pipe.Pipe, data db.Data) {
+	if f, ok := data.Data["as_file"].(string); ok && p.Input.AsFile != "" {
+		if err := os.Remove(f); err != nil {
+			log.WithField("pipe", p.Name).Errorf("could not remove as_file tmp file: %v", err)
+		}
+	}
+}
+
 func ErrorHandler(ctx context.Context, task *asynq.Task, err error, saveFailed string) {
 	retried, _ := asynq.GetRetryCount(ctx)
 	maxRetry, _ := asynq.GetMaxRetry(ctx)
@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/rverton/pipers/asset"
+	"github.com/rverton/pipers/db"
+	"github.com/rverton/pipers/pipe"
+)
+
+func testTask(p pipe.Pipe, data db.Data) *asynq.Task {
+	pipeBytes, _ := json.Marshal(p)
+	dataBytes, _ := json.Marshal(data)
+
+	return asynq.NewTask(TASK_PIPE, map[string]interface{}{
+		"pipe": string(pipeBytes),
+		"data": string(dataBytes),
+	})
+}
+
+func testPipe() pipe.Pipe {
+	var p pipe.Pipe
+	p.Name = "test_pipe"
+	p.Command = "echo ${.input.asset}"
+	p.Output.Table = "domains"
+	p.Output.Ident = "${.output}"
+	p.Output.AssetType = asset.TEXT
+	return p
+}
+
+func TestHandlerHostCheck(t *testing.T) {
+	if err := pipe.ConfigureHosts(pipe.HostCheck{OnFailure: pipe.ON_FAILURE_SKIP}); err != nil {
+		t.Fatal(err)
+	}
+	defer pipe.ConfigureHosts(pipe.HostCheck{})
+
+	t.Run("records skipped unresolved assets", func(t *testing.T) {
+		ds := &db.MemoryService{}
+		data := db.Data{Id: "missing.invalid", Asset: "missing.invalid", AssetType: "domain", Target: "example"}
+
+		if err := Handler(context.Background(), testTask(testPipe(), data), ds); err != nil {
+			t.Fatal(err)
+		}
+
+		tasks := ds.Tasks()
+		if len(tasks) != 1 || !strings.HasPrefix(tasks[0].Note, "skipped: resolution failed") || len(ds.Records("domains")) != 0 {
+			t.Errorf("want task to be skipped with a note, got %+v", tasks)
+		}
+
+		// the run is recorded, the asset is not due again within the interval
+		if due, _ := ds.Due("test_pipe", "", "example", []string{"missing.invalid"}, time.Hour); len(due) != 0 {
+			t.Errorf("want skipped asset not to be due, got %v", due)
+		}
+	})
+
+	t.Run("does not resolve assets without host", func(t *testing.T) {
+		ds := &db.MemoryService{}
+		data := db.Data{Id: "admin@missing.invalid", Asset: "admin@missing.invalid", AssetType: "email", Target: "example"}
+
+		if err := Handler(context.Background(), testTask(testPipe(), data), ds); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ds.Tasks()) != 1 || len(ds.Records("domains")) != 1 {
+			t.Errorf("want email asset to be processed, got %+v", ds.Tasks())
+		}
+	})
+
+	t.Run("does not check as_file tasks", func(t *testing.T) {
+		ds := &db.MemoryService{}
+
+		f, err := ioutil.TempFile(os.TempDir(), "pipers-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("a.example.com\nb.example.com\n")
+		f.Close()
+		defer os.Remove(f.Name())
+
+		p := testPipe()
+		p.Input.Table = "domains"
+		p.Input.AsFile = "${.input.asset}"
+		p.Command = "cat ${.input.as_file}"
+
+		data := db.Data{Id: "example", Target: "example", Data: map[string]interface{}{"as_file": f.Name()}}
+
+		if err := Handler(context.Background(), testTask(p, data), ds); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ds.Records("domains")) != 2 {
+			t.Errorf("want as_file task to be processed, got %+v", ds.Tasks())
+		}
+		if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+			t.Errorf("want as_file input to be removed")
+		}
+	})
+}
+
+func TestHandlerPaused(t *testing.T) {
+	ds := &db.MemoryService{}
+	data := db.Data{Id: "a", Asset: "a", AssetType: "text", Target: "example"}
+
+	ds.PausePipe("test_pipe", true)
+
+	if err := Handler(context.Background(), testTask(testPipe(), data), ds); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds.Tasks()) != 0 || len(ds.Records("domains")) != 0 {
+		t.Errorf("want task of paused pipe to be dropped, got %+v", ds.Tasks())
+	}
+
+	ds.PausePipe("test_pipe", false)
+
+	if err := Handler(context.Background(), testTask(testPipe(), data), ds); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ds.Tasks()) != 1 || len(ds.Records("domains")) != 1 {
+		t.Errorf("want task to be processed once resumed, got %+v", ds.Tasks())
+	}
+}
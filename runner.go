@@ -19,23 +19,45 @@ import (
 
 var SCHEDULER_SLEEP = time.Minute * 1
 
-func runAsFile(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
+func runAsFile(p pipe.Pipe, client *asynq.Client, ds db.DataService, paused db.Paused) error {
 	logger := log.WithField("pipe", p.Name)
 
-	targets, err := ds.RetrieveTargets()
+	registered, err := ds.RetrieveTargets()
 	if err != nil {
 		return fmt.Errorf("retrieving targets failed")
 	}
 
 	interval, _ := p.Interval()
+	filter, _ := p.InputFilter()
 
-	for _, target := range targets {
-
-		if !ds.ShouldRun(p.Name, target, interval) {
+	// as_file tasks are tracked with the target as ident
+	var due []string
+	for _, t := range registered {
+		if paused.Targets[t] {
+			logger.WithField("target", t).Debug("target paused, skipping")
 			continue
 		}
 
-		rows, err := ds.RetrieveByTarget(p.Input.Table, p.Input.Filter, target)
+		d, err := ds.Due(p.Name, p.Source(), t, []string{t}, interval)
+		if err != nil {
+			return fmt.Errorf("retrieving due targets failed: %v", err)
+		}
+		due = append(due, d...)
+	}
+
+	exclusions := pipe.NewExclusions(ds)
+
+	for _, target := range due {
+
+		var rows []db.Data
+		var inc db.Increment
+
+		if p.Input.Incremental {
+			inc, err = ds.RetrieveIncrement(p.Input.Table, p.Name, target, filter, p.FullRefresh())
+			rows = inc.Rows
+		} else {
+			rows, err = ds.RetrieveByTarget(p.Input.Table, filter, target)
+		}
 		if err != nil {
 			return fmt.Errorf("could not retrieve input: %v", err)
 		}
@@ -47,11 +69,18 @@ func runAsFile(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 			return fmt.Errorf("could not create tmp file: %v", err)
 		}
 
+		// idents and assets of the written records, outputs are linked
+		// to the records they were produced from
+		inputs := make(map[string]interface{})
+
 		count := 0
-		for rows.Next() {
-			err := rows.Scan(&data.Id, &data.Asset, &data.Target, &data.Data)
-			if err != nil {
-				return fmt.Errorf("retrieving pipe input data failed: %v", err)
+		for _, data = range rows {
+			if data.Data == nil {
+				data.Data = make(map[string]interface{})
+			}
+
+			if !inScope(logger, exclusions, data) {
+				continue
 			}
 
 			tpl, err := pipe.Tpl(p.Input.AsFile, map[string]interface{}{
@@ -63,6 +92,7 @@ func runAsFile(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 			}
 
 			tmpInputFile.WriteString(tpl + "\n")
+			inputs[data.Id] = data.Asset
 			count++
 		}
 
@@ -74,9 +104,11 @@ func runAsFile(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 
 		// take last data object and put input filename in
 		newData := db.Data{
+			Id:     target,
 			Target: data.Target,
 			Data: map[string]interface{}{
-				"as_file": tmpInputFile.Name(),
+				"as_file":        tmpInputFile.Name(),
+				"as_file_inputs": inputs,
 			},
 		}
 
@@ -96,9 +128,17 @@ func runAsFile(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 			// to be run too often
 			// TODO: move to retriever and add a redis check for this task
 			ds.AddTask(db.Task{
-				Pipe:  p.Name,
-				Ident: target,
+				Pipe:   p.Name,
+				Table:  p.Source(),
+				Target: data.Target,
+				Ident:  target,
 			})
+
+			if p.Input.Incremental {
+				if err := ds.CommitIncrement(p.Input.Table, p.Name, inc); err != nil {
+					return fmt.Errorf("moving watermark failed: %v", err)
+				}
+			}
 		}
 
 	}
@@ -106,12 +146,15 @@ func runAsFile(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 	return nil
 }
 
-func runSingle(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
+func runSingle(p pipe.Pipe, client *asynq.Client, ds db.DataService, paused db.Paused) error {
+	var err error
+
 	interval, _ := p.Interval()
 	count := 0
 	countAdded := 0
 
 	logger := log.WithFields(log.Fields{"pipe": p.Name})
+	exclusions := pipe.NewExclusions(ds)
 
 	// retrieve data from file?
 	if p.Input.File != "" {
@@ -122,19 +165,36 @@ func runSingle(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 		}
 		defer file.Close()
 
+		var lines []string
+
 		scanner := bufio.NewScanner(file)
 		for scanner.Scan() {
-			line := scanner.Text()
+			lines = append(lines, scanner.Text())
+		}
 
-			if !ds.ShouldRun(p.Name, line, interval) {
-				continue
-			}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+
+		count = len(lines)
+
+		target := filepath.Base(p.Input.File)
+
+		due, err := ds.Due(p.Name, p.Source(), target, lines, interval)
+		if err != nil {
+			return fmt.Errorf("retrieving due lines failed: %v", err)
+		}
 
+		for _, line := range due {
 			// enqueue task
 			data := db.Data{
 				Id:     line,
 				Asset:  line,
-				Target: filepath.Base(p.Input.File),
+				Target: target,
+			}
+
+			if paused.Targets[data.Target] || !inScope(logger, exclusions, data) {
+				continue
 			}
 
 			if err := queue.EnqueuePipe(p, data, client); err != nil {
@@ -147,24 +207,32 @@ func runSingle(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 			}
 		}
 
-		if err := scanner.Err(); err != nil {
-			log.Fatal(err)
-		}
-
 	} else {
 
-		rows, err := ds.Retrieve(p.Input.Table, p.Name, p.Input.Filter, p.Input.Threshold, interval)
+		filter, _ := p.InputFilter()
+
+		var rows []db.Data
+		var inc db.Increment
+
+		// incremental inputs only pass records created since the
+		// watermark, all other records not run within the interval.
+		// Watermarks of paused targets are kept.
+		if p.Input.Incremental {
+			inc, err = ds.RetrieveIncrement(p.Input.Table, p.Name, "", filter, p.FullRefresh())
+			inc = inc.Without(paused.Targets)
+			rows = inc.Rows
+		} else {
+			rows, err = ds.Retrieve(p.Input.Table, p.Name, filter, interval)
+		}
 		if err != nil {
 			return fmt.Errorf("could not retrieve input: %v", err)
 		}
 
-		for rows.Next() {
+		for _, data := range rows {
 			count++
 
-			data := db.Data{}
-			err := rows.Scan(&data.Id, &data.Asset, &data.Target, &data.Data)
-			if err != nil {
-				return fmt.Errorf("scanning pipe input failed: %v", err)
+			if paused.Targets[data.Target] || !inScope(logger, exclusions, data) {
+				continue
 			}
 
 			// enqueue task
@@ -177,6 +245,12 @@ func runSingle(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 				countAdded += 1
 			}
 		}
+
+		if p.Input.Incremental {
+			if err := ds.CommitIncrement(p.Input.Table, p.Name, inc); err != nil {
+				return fmt.Errorf("moving watermarks failed: %v", err)
+			}
+		}
 	}
 
 	logger.WithFields(log.Fields{
@@ -187,6 +261,30 @@ func runSingle(p pipe.Pipe, client *asynq.Client, ds db.DataService) error {
 	return nil
 }
 
+// inScope checks an input against the scope of its target before it
+// is enqueued, errors are logged and skip the input
+func inScope(logger *log.Entry, exclusions *pipe.Exclusions, data db.Data) bool {
+	d, err := exclusions.Check(data.Target, data.Asset)
+	if err != nil {
+		logger.WithFields(log.Fields{
+			"ident": data.Id,
+			"error": err,
+		}).Error("checking scope failed")
+		return false
+	}
+
+	if !d.InScope {
+		logger.WithFields(log.Fields{
+			"ident":  data.Id,
+			"asset":  data.Asset,
+			"rule":   d.Rule,
+			"reason": d.Reason,
+		}).Info("input not in scope, skipping")
+	}
+
+	return d.InScope
+}
+
 // run will be executed for each pipe and is reponsible for
 // scheduling tasks periodically
 func run(p pipe.Pipe, client *asynq.Client, ds db.DataService, wg *sync.WaitGroup) {
@@ -194,17 +292,38 @@ func run(p pipe.Pipe, client *asynq.Client, ds db.DataService, wg *sync.WaitGrou
 
 	for {
 
+		// pauses are read on every run, so they apply without a restart.
+		// A paused pipe is neither scheduled nor swept, its records are
+		// not refreshed while paused.
+		paused, err := ds.RetrievePaused()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"pipe":  p.Name,
+				"error": err,
+			}).Error("retrieving paused pipes and targets failed")
+
+			time.Sleep(SCHEDULER_SLEEP)
+			continue
+		}
+
+		if paused.Pipes[p.Name] {
+			log.WithField("pipe", p.Name).Debug("pipe paused, skipping")
+
+			time.Sleep(SCHEDULER_SLEEP)
+			continue
+		}
+
 		// based on as_file, create a task with all results at once
 		// or a single task for each returned record
 		if p.Input.AsFile != "" {
-			if err := runAsFile(p, client, ds); err != nil {
+			if err := runAsFile(p, client, ds, paused); err != nil {
 				log.WithFields(log.Fields{
 					"pipe":  p.Name,
 					"error": err,
 				}).Error("running as file failed")
 			}
 		} else {
-			if err := runSingle(p, client, ds); err != nil {
+			if err := runSingle(p, client, ds, paused); err != nil {
 				log.WithFields(log.Fields{
 					"pipe":  p.Name,
 					"error": err,
@@ -213,6 +332,13 @@ func run(p pipe.Pipe, client *asynq.Client, ds db.DataService, wg *sync.WaitGrou
 
 		}
 
+		if err := pipe.Sweep(p, ds); err != nil {
+			log.WithFields(log.Fields{
+				"pipe":  p.Name,
+				"error": err,
+			}).Error("sweeping removed records failed")
+		}
+
 		time.Sleep(SCHEDULER_SLEEP)
 	}
 }
@@ -0,0 +1,87 @@
+package scope
+
+import "fmt"
+
+// Decision is the result of checking an asset against a scope
+type Decision struct {
+	InScope bool
+	Rule    string // the rule which decided, empty if none applied
+	Reason  string
+}
+
+// RuleSet are parsed in and out of scope rules
+type RuleSet struct {
+	In  []Rule
+	Out []Rule
+}
+
+// Engine checks assets against the rules of their target
+type Engine struct {
+	targets map[string]RuleSet
+}
+
+// New parses the rules of all targets
+func New(targets Targets) (*Engine, error) {
+	e := &Engine{targets: make(map[string]RuleSet)}
+
+	for target, rules := range targets {
+		var set RuleSet
+
+		for _, s := range rules.In {
+			r, err := ParseRule(s)
+			if err != nil {
+				return nil, fmt.Errorf("in scope of %v: %v", target, err)
+			}
+			set.In = append(set.In, r)
+		}
+
+		for _, s := range rules.Out {
+			r, err := ParseRule(s)
+			if err != nil {
+				return nil, fmt.Errorf("out of scope of %v: %v", target, err)
+			}
+			set.Out = append(set.Out, r)
+		}
+
+		e.targets[target] = set
+	}
+
+	return e, nil
+}
+
+// Check decides if an asset is in scope of a target. Out of scope rules
+// and excluded rules win, if a target has in scope rules one of
+// them has to match. Targets without in scope rules accept every asset.
+func (e *Engine) Check(target, asset string, excluded []Rule) Decision {
+	a := ParseAsset(asset)
+
+	var in, out []Rule
+	if e != nil {
+		in = append(append(in, e.targets[target].In...), e.targets[ALL_TARGETS].In...)
+		out = append(append(out, e.targets[target].Out...), e.targets[ALL_TARGETS].Out...)
+	}
+
+	for _, r := range out {
+		if r.Match(a) {
+			return Decision{Rule: r.String(), Reason: "out of scope"}
+		}
+	}
+
+	for _, r := range excluded {
+		if r.Match(a) {
+			return Decision{Rule: r.String(), Reason: "excluded"}
+		}
+	}
+
+	if len(in) == 0 {
+		return Decision{InScope: true}
+	}
+
+	for _, r := range in {
+		if r.Match(a) {
+			return Decision{InScope: true, Rule: r.String(), Reason: "in scope"}
+		}
+	}
+
+	return Decision{Reason: "no in scope rule matched"}
+}
@@ -0,0 +1,233 @@
+package scope
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ALL_TARGETS is the target key of rules applying to every target
+const ALL_TARGETS = "*"
+
+// Rules are the in and out of scope rules of a target. Supported rules:
+//
+//	example.com          the domain itself
+//	*.example.com        all subdomains of example.com
+//	10.0.0.0/8, 1.2.3.4  a network or a single IP
+//	https://example.com/ a URL prefix, only matching URL assets
+//	re:^dev\.            a regular expression on the whole asset
+type Rules struct {
+	In  []string `yaml:"in"`
+	Out []string `yaml:"out"`
+}
+
+// Targets holds the rules of every target
+type Targets map[string]Rules
+
+type ruleKind int
+
+const (
+	ruleDomain ruleKind = iota
+	ruleWildcard
+	ruleNetwork
+	ruleURL
+	ruleRegexp
+)
+
+// Rule is a single parsed scope rule
+type Rule struct {
+	raw     string
+	kind    ruleKind
+	value   string
+	network *net.IPNet
+	re      *regexp.Regexp
+}
+
+func (r Rule) String() string {
+	return r.raw
+}
+
+// ParseRule parses a rule in one of the formats described at Rules
+func ParseRule(s string) (Rule, error) {
+	raw := strings.TrimSpace(s)
+	r := Rule{raw: raw}
+
+	switch {
+	case raw == "":
+		return r, fmt.Errorf("empty rule")
+
+	case strings.HasPrefix(raw, "re:"):
+		re, err := regexp.Compile(raw[3:])
+		if err != nil {
+			return r, fmt.Errorf("rule %q: %v", raw, err)
+		}
+		r.kind, r.re = ruleRegexp, re
+
+	case strings.Contains(raw, "://"):
+		u, ok := normalizeURL(raw)
+		if !ok {
+			return r, fmt.Errorf("rule %q: invalid URL", raw)
+		}
+		r.kind, r.value = ruleURL, u
+
+	case strings.Contains(raw, "/"):
+		_, network, err := net.ParseCIDR(raw)
+		if err != nil {
+			return r, fmt.Errorf("rule %q: %v", raw, err)
+		}
+		r.kind, r.network = ruleNetwork, network
+
+	case net.ParseIP(raw) != nil:
+		ip := net.ParseIP(raw)
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		r.kind, r.network = ruleNetwork, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+
+	case strings.HasPrefix(raw, "*."):
+		domain := normalizeHost(raw[2:])
+		if !validDomain(domain) {
+			return r, fmt.Errorf("rule %q: invalid domain", raw)
+		}
+		r.kind, r.value = ruleWildcard, domain
+
+	default:
+		domain := normalizeHost(raw)
+		if !validDomain(domain) {
+			return r, fmt.Errorf("rule %q: invalid domain", raw)
+		}
+		r.kind, r.value = ruleDomain, domain
+	}
+
+	return r, nil
+}
+
+// Match checks if the rule matches an asset
+func (r Rule) Match(a Asset) bool {
+	switch r.kind {
+	case ruleDomain:
+		return a.ip == nil && a.host == r.value
+	case ruleWildcard:
+		return a.ip == nil && strings.HasSuffix(a.host, "."+r.value)
+	case ruleNetwork:
+		return a.ip != nil && r.network.Contains(a.ip)
+	case ruleURL:
+		if a.url == "" || !strings.HasPrefix(a.url, r.value) {
+			return false
+		}
+		// the prefix has to end at a path boundary, so that
+		// https://example.com does not match https://example.com.evil.org
+		rest := a.url[len(r.value):]
+		return rest == "" || strings.HasSuffix(r.value, "/") || strings.ContainsAny(rest[:1], "/?#")
+	case ruleRegexp:
+		return r.re.MatchString(a.raw)
+	}
+
+	return false
+}
+
+// ExclusionRules returns the out of scope rules of an excluded asset.
+// Excluded domains also exclude all of their subdomains.
+func ExclusionRules(asset string) ([]Rule, error) {
+	r, err := ParseRule(asset)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.kind != ruleDomain {
+		return []Rule{r}, nil
+	}
+
+	return []Rule{r, {raw: "*." + r.value, kind: ruleWildcard, value: r.value}}, nil
+}
+
+// Covers checks if an asset is covered by the exclusion of another
+// asset, like a domain by itself or a parent domain, or a URL by a
+// prefix. Assets parsed as regular expressions only cover themselves.
+func Covers(parent, asset string) bool {
+	if parent == asset {
+		return true
+	}
+
+	rules, err := ExclusionRules(parent)
+	if err != nil {
+		return false
+	}
+
+	a := ParseAsset(asset)
+	for _, r := range rules {
+		if r.kind != ruleRegexp && r.Match(a) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Asset is an asset prepared for matching, it can be a domain, an IP,
+// a host with port or a URL
+type Asset struct {
+	raw  string
+	host string
+	ip   net.IP
+	url  string
+}
+
+// ParseAsset extracts the host and URL of an asset
+func ParseAsset(s string) Asset {
+	a := Asset{raw: s, host: s}
+
+	if strings.Contains(s, "://") {
+		if u, ok := normalizeURL(s); ok {
+			a.url = u
+			parsed, _ := url.Parse(u)
+			a.host = parsed.Hostname()
+		}
+	} else if host, _, err := net.SplitHostPort(s); err == nil {
+		a.host = host
+	}
+
+	a.host = normalizeHost(strings.Trim(a.host, "[]"))
+	a.ip = net.ParseIP(a.host)
+
+	return a
+}
+
+// Host returns the lower cased host of an asset without port
+func (a Asset) Host() string {
+	return a.host
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// normalizeURL lower cases scheme and host of a URL
+func normalizeURL(s string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	return u.String(), true
+}
+
+func validDomain(s string) bool {
+	if s == "" || strings.ContainsAny(s, "*/:@ \t") {
+		return false
+	}
+
+	for _, label := range strings.Split(s, ".") {
+		if label == "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,116 @@
+package scope
+
+import "testing"
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule  string
+		asset string
+		want  bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com:8443", true},
+		{"*.example.com", "example.com", false},
+		{"*.ample.com", "www.example.com", false},
+		{"ample.com", "example.com", false},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "http://10.1.2.3:8080/admin", true},
+		{"10.0.0.0/8", "11.1.2.3", false},
+		{"1.2.3.4", "1.2.3.4:443", true},
+		{"2001:db8::/32", "[2001:db8::1]:443", true},
+		{"https://example.com/app", "https://EXAMPLE.com/app/login", true},
+		{"https://example.com/app", "https://example.com/app?x=1", true},
+		{"https://example.com/app", "https://example.com/apple", false},
+		{"https://example.com", "https://example.com.evil.org/", false},
+		{"https://example.com", "http://example.com/", false},
+		{"https://example.com", "example.com", false},
+		{`re:^dev\.`, "dev.example.com", true},
+		{`re:^dev\.`, "www.dev.example.com", false},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseRule(tt.rule)
+		if err != nil {
+			t.Errorf("%q: %v", tt.rule, err)
+			continue
+		}
+
+		if got := r.Match(ParseAsset(tt.asset)); got != tt.want {
+			t.Errorf("%q on %q: want = %v, got = %v", tt.rule, tt.asset, tt.want, got)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		parent string
+		asset  string
+		want   bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "a.b.example.com", true},
+		{"example.com", "https://www.example.com/login", true},
+		{"example.com", "example.org", false},
+		{"1.2.3.4", "1.2.3.4:443", true},
+		{"https://example.com/app", "https://example.com/app/login", true},
+		{"example.com:443", "example.com:443", true},
+		{"example.com:443", "www.example.com", false},
+		{`re:.*`, "example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := Covers(tt.parent, tt.asset); got != tt.want {
+			t.Errorf("%q covers %q: want = %v, got = %v", tt.parent, tt.asset, tt.want, got)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, rule := range []string{"", "re:(", "10.0.0.0/33", "*.", "foo*.com", "https://"} {
+		if _, err := ParseRule(rule); err == nil {
+			t.Errorf("%q: want error", rule)
+		}
+	}
+}
+
+func TestEngineCheck(t *testing.T) {
+	e, err := New(Targets{
+		"example": {In: []string{"*.example.com", "example.com"}, Out: []string{"admin.example.com"}},
+		"*":       {Out: []string{"10.0.0.0/8"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excluded, _ := ExclusionRules("dev.example.com")
+
+	tests := []struct {
+		target string
+		asset  string
+		want   bool
+		rule   string
+	}{
+		{"example", "www.example.com", true, "*.example.com"},
+		{"example", "example.com", true, "example.com"},
+		{"example", "admin.example.com", false, "admin.example.com"},
+		{"example", "example.org", false, ""},
+		{"example", "a.dev.example.com", false, "*.dev.example.com"},
+		{"other", "example.org", true, ""},
+		{"other", "10.0.0.1", false, "10.0.0.0/8"},
+	}
+
+	for _, tt := range tests {
+		d := e.Check(tt.target, tt.asset, excluded)
+		if d.InScope != tt.want || d.Rule != tt.rule {
+			t.Errorf("%v %q: want = %v (%q), got = %+v", tt.target, tt.asset, tt.want, tt.rule, d)
+		}
+	}
+
+	var none *Engine
+	if d := none.Check("example", "example.org", nil); !d.InScope {
+		t.Errorf("want assets in scope without rules, got %+v", d)
+	}
+}
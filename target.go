@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rverton/pipers/asset"
+	"github.com/rverton/pipers/db"
+	"github.com/rverton/pipers/pipe"
+)
+
+const targetUsage = "usage: target <add|list|pause|resume|remove> [flags] <name>"
+
+// target runs the target command, which manages the target registry.
+// tables are the data tables used by pipes.
+func target(args []string, ds db.DataService, tables []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(targetUsage)
+	}
+
+	switch args[0] {
+	case "add":
+		return targetAdd(args[1:], ds)
+	case "list":
+		return targetList(args[1:], ds, tables)
+	case "pause", "resume":
+		return targetPause(args[0], args[1:], ds)
+	case "remove":
+		return targetRemove(args[1:], ds, tables)
+	}
+
+	return fmt.Errorf(targetUsage)
+}
+
+// targetAdd registers a target and imports its scope seeds
+func targetAdd(args []string, ds db.DataService) error {
+	fs := flag.NewFlagSet("target add", flag.ExitOnError)
+	description := fs.String("description", "", "description of the target")
+	table := fs.String("table", db.EXCLUSION_TABLE_DEFAULT, "table the seeds are imported into")
+	assetType := fs.String("type", string(asset.TYPE_DEFAULT), "asset_type of the seeds")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("usage: target add [-description d] [-table t] [-type t] <name> [seed...]")
+	}
+
+	name := fs.Arg(0)
+	if err := ds.AddTarget(db.Target{Name: name, Description: *description}); err != nil {
+		return err
+	}
+
+	fmt.Printf("added target %v\n", name)
+
+	seeds := fs.Args()[1:]
+	if len(seeds) == 0 {
+		return nil
+	}
+
+	if m, ok := ds.(db.Migrator); ok {
+		if err := m.Migrate([]string{*table}); err != nil {
+			return err
+		}
+	}
+
+	result, err := pipe.Import(ds, strings.NewReader(strings.Join(seeds, "\n")), pipe.ImportOptions{
+		Table:     *table,
+		Target:    name,
+		Format:    pipe.IMPORT_TEXT,
+		AssetType: asset.Type(*assetType),
+		Data:      map[string]interface{}{"scope": true},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("seeds: %v inserted, %v existing, %v skipped\n", result.Inserted, result.Existing, result.SkippedTotal())
+	return nil
+}
+
+// targetList prints all targets with their record counts per table
+func targetList(args []string, ds db.DataService, tables []string) error {
+	fs := flag.NewFlagSet("target list", flag.ExitOnError)
+	fs.Parse(args)
+
+	targets, err := ds.ListTargets()
+	if err != nil {
+		return err
+	}
+
+	tables = append([]string{}, tables...)
+	sort.Strings(tables)
+
+	counts := make(map[string]map[string]int64)
+	for _, table := range tables {
+		if counts[table], err = ds.CountRecords(table); err != nil {
+			return fmt.Errorf("counting records of %v failed: %v", table, err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "NAME\tSTATUS\tCREATED")
+	for _, table := range tables {
+		fmt.Fprintf(w, "\t%v", strings.ToUpper(table))
+	}
+	fmt.Fprintf(w, "\tDESCRIPTION\n")
+
+	for _, t := range targets {
+		status := "active"
+		if t.Paused {
+			status = "paused"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v", t.Name, status, t.Created.Format("2006-01-02"))
+		for _, table := range tables {
+			fmt.Fprintf(w, "\t%v", counts[table][t.Name])
+		}
+		fmt.Fprintf(w, "\t%v\n", t.Description)
+	}
+
+	return w.Flush()
+}
+
+// targetPause pauses or resumes the scheduling of a target
+func targetPause(action string, args []string, ds db.PauseService) error {
+	fs := flag.NewFlagSet("target "+action, flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: target %v <name>", action)
+	}
+
+	if err := ds.PauseTarget(fs.Arg(0), action == "pause"); err != nil {
+		return err
+	}
+
+	fmt.Printf("%vd target %v\n", action, fs.Arg(0))
+	return nil
+}
+
+// targetRemove deletes a target with all its records and their tasks,
+// alerts, history and lineage
+func targetRemove(args []string, ds db.TargetService, tables []string) error {
+	fs := flag.NewFlagSet("target remove", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "confirm deleting the target with all its data")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: target remove -yes <name>")
+	}
+
+	name := fs.Arg(0)
+	if !*yes {
+		return fmt.Errorf("removing %v deletes all its data, confirm with -yes", name)
+	}
+
+	deleted, err := ds.RemoveTarget(name, tables)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for table := range deleted {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "TABLE\tDELETED\n")
+	for _, table := range names {
+		fmt.Fprintf(w, "%v\t%v\n", table, deleted[table])
+	}
+	fmt.Fprintf(w, "removed target %v\n", name)
+	return w.Flush()
+}